@@ -2,62 +2,170 @@ package main
 
 import (
 	"./mvb"
+	"bufio"
 	"fmt"
-	"gopkg.in/alecthomas/kingpin.v2"
+	"github.com/alecthomas/kingpin/v2"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
-	"sync"
 )
 
 var (
 	app     = kingpin.New(os.Args[0], "多版本备份工具")
 	verbose = app.Flag("verbose", "输出调试信息").Short('v').Bool()
+	format  = app.Flag("format", "输出格式：text、json、ndjson").Default("text").Enum("text", "json", "ndjson")
+	jsonOut = app.Flag("json", "以JSON格式输出，同 --format json").Bool()
 
 	initCommand = app.Command("init", "初始化当前文件夹作为备份存储空间")
 	initPath    = initCommand.Arg("path", "要备份的文件夹").Required().String()
 
 	backupCommand = app.Command("backup", "备份")
+	backupStdin         = backupCommand.Flag("stdin", "备份标准输入的数据，保存为只包含一个文件的版本").Bool()
+	backupStdinFilename = backupCommand.Flag("stdin-filename", "使用 --stdin 时的文件名").Default("stdin").String()
+
+	importCommand = app.Command("import", "将tar、tar.gz或zip归档导入为一个版本")
+	importArchive = importCommand.Arg("archive", "归档文件，为空时从标准输入读取tar或tar.gz").Default("").String()
+
+	watchCommand     = app.Command("watch", "监视源文件夹，发生变化后自动备份")
+	watchQuiet       = watchCommand.Flag("quiet", "变化停止多久后创建新版本").Default(mvb.WATCH_QUIET.String()).Duration()
+	watchMaxInterval = watchCommand.Flag("max-interval", "持续变化时，距首次变化多久后创建新版本").Default(mvb.WATCH_MAX_INTERVAL.String()).Duration()
 
 	restoreCommand = app.Command("restore", "还原")
 	restoreVersion = restoreCommand.Arg("version", "要还原的版本，默认为最新版本").Default("").String()
 	restorePath    = restoreCommand.Arg("path", "要还原到的文件夹，默认为备份文件夹").Default("").String()
+	restorePaths   = restoreCommand.Arg("paths", "只还原匹配的路径，以/结尾匹配文件夹，支持通配符，不含/时同时匹配文件名").Strings()
+	restoreInclude = restoreCommand.Flag("include", "只还原匹配的路径，同路径参数，可多次指定").Strings()
+	restoreDryRun  = restoreCommand.Flag("dry-run", "只输出还原计划，不做任何修改").Bool()
+	restoreNoDel   = restoreCommand.Flag("no-delete", "不删除目标文件夹中版本里不存在的文件及文件夹").Bool()
+	restoreOverwr  = restoreCommand.Flag("overwrite", "已存在文件的覆盖策略：always 全部覆盖，never 不覆盖，if-newer 版本中的文件较新时覆盖，if-changed 内容不同时覆盖").Default("if-changed").Enum("always", "never", "if-newer", "if-changed")
+	restoreSuffix  = restoreCommand.Flag("backup-suffix", "覆盖或删除文件前，将原文件重命名为加上此后缀的文件，如 .orig").String()
+	restoreVerify  = restoreCommand.Flag("verify", "还原后重新计算文件SHA1，与版本快照比较").Bool()
+	restoreMode    = restoreCommand.Flag("mode", "还原文件的方式：copy 复制，hardlink 硬链接，reflink 写时复制，不支持时改为复制").Default("copy").Enum("copy", "hardlink", "reflink")
 
 	linkCommand = app.Command("link", "通过符号链接，创建版本文件视图")
 	linkVersion = linkCommand.Arg("version", "要链接的版本").Required().String()
 	linkPath    = linkCommand.Arg("path", "要链接的文件夹，必须存在且为空文件夹").Required().String()
+	linkMode    = linkCommand.Flag("mode", "链接方式：symlink 绝对路径符号链接，symlink-relative 相对路径符号链接，hardlink 硬链接，reflink 写时复制").Default("symlink").Enum("symlink", "symlink-relative", "hardlink", "reflink")
 
 	listCommand = app.Command("list", "查看所有备份版本")
-	listVersion = listCommand.Arg("version", "短版本").Default("").String()
+	listVersion = listCommand.Arg("version", "短版本或版本范围").Default("").String()
 
 	getCommand = app.Command("get", "读取备份内容")
 	getVersion = getCommand.Arg("version", "版本与路径同时为空时，读取版本反向索引；版本不为空时，读取版本特定数据").Default("").String()
 	getPath    = getCommand.Arg("path", "路径为空时，读取版本快照；路径不为空时，读取该版本文件内容").Default("").String()
 
+	exportCommand = app.Command("export", "将版本导出为tar或zip归档")
+	exportVersion = exportCommand.Arg("version", "要导出的版本或版本范围").Required().String()
+	exportPrefix  = exportCommand.Arg("path", "只导出以此路径开头的文件及文件夹").Default("").String()
+	exportOutput  = exportCommand.Flag("output", "输出文件，默认输出到标准输出").Short('o').Default("").String()
+	exportType    = exportCommand.Flag("type", "归档格式：tar、tar.gz、zip，默认根据输出文件扩展名判断，无法判断时为tar").Short('t').Default("").Enum("", "tar", "tar.gz", "zip")
+
 	deleteCommand = app.Command("delete", "删除指定的版本")
-	deleteVersion = deleteCommand.Arg("version", "版本").Required().String()
+	deleteVersion = deleteCommand.Arg("version", "版本或版本范围").Required().String()
+	deleteForce   = deleteCommand.Flag("force", "允许删除所有版本").Bool()
+	deleteKeep    = deleteCommand.Flag("keep", "保留最新的N个版本，即使在指定的版本中").PlaceHolder("N").Int()
 
 	diffCommand  = app.Command("diff", "对比两个版本的差异")
-	diffVersionA = diffCommand.Arg("version a", "版本A，默认为最新版本").Default("").String()
+	diffVersionA = diffCommand.Arg("version a", "版本A，默认为最新版本；为版本范围时比较范围内的第一个与最后一个版本").Default("").String()
 	diffVersionB = diffCommand.Arg("version b", "版本B，默认为将要备份的版本").Default("").String()
+	diffPatch    = diffCommand.Flag("patch", "输出文本文件内容的统一格式差异").Short('p').Bool()
+	diffContext  = diffCommand.Flag("unified", "统一格式差异的上下文行数").Short('U').Default("3").Int()
+	diffPaths    = diffCommand.Flag("path", "只比较匹配的路径，以/结尾匹配文件夹，支持通配符，可多次指定").Strings()
+	diffNoRename = diffCommand.Flag("no-renames", "不检测重命名及移动").Bool()
+	diffRenames  = diffCommand.Flag("find-renames", "同时将内容相似度不低于该百分比的文本文件视为重命名，0为只检测内容相同的文件").Short('M').Default("0").Int()
+	diffStat     = diffCommand.Flag("stat", "按顶层文件夹统计新增、删除、修改的文件数及大小变化").Bool()
+	diffMetadata = diffCommand.Flag("metadata", "同时比较内容相同但最后修改时间不同的文件及文件夹").Bool()
 
 	previewCommand = app.Command("preview", "预览将要备份的版本")
 
-	checkCommand = app.Command("check", "校验备份文件完整性")
+	logCommand    = app.Command("log", "查看文件或文件夹在各版本中的变化历史")
+	logPath       = logCommand.Arg("path", "文件或文件夹路径，文件夹需以/结尾").Required().String()
+	logFollow     = logCommand.Flag("follow", "跟踪文件重命名").Bool()
+	logReverse    = logCommand.Flag("reverse", "按时间正序输出").Bool()
+	logNameStatus = logCommand.Flag("name-status", "文件夹路径时，同时列出每个版本中变化的文件").Bool()
+
+	findCommand  = app.Command("find", "在所有版本中查找文件")
+	findPatterns = findCommand.Arg("pattern", "路径模式，以/结尾匹配文件夹，支持通配符，不含/时同时匹配文件名").Strings()
+	findVersion  = findCommand.Flag("version", "只查找匹配的版本，默认为所有版本").String()
+	findNewer    = findCommand.Flag("newer", "最后修改时间晚于此时间").String()
+	findOlder    = findCommand.Flag("older", "最后修改时间早于此时间").String()
+	findSize     = findCommand.Flag("size", "文件大小，+N 大于N，-N 小于N，N 等于N，支持K、M、G单位").String()
+	findSha1     = findCommand.Flag("sha1", "文件SHA1，支持短格式").String()
+
+	grepCommand    = app.Command("grep", "在所有版本的文件内容中查找匹配的行")
+	grepPattern    = grepCommand.Arg("regexp", "正则表达式").Required().String()
+	grepVersion    = grepCommand.Arg("version", "只查找匹配的版本，默认为所有版本").Default("").String()
+	grepPrefix     = grepCommand.Arg("path", "只查找以此路径开头的文件").Default("").String()
+	grepIgnoreCase = grepCommand.Flag("ignore-case", "忽略大小写").Short('i').Bool()
+	grepFilesOnly  = grepCommand.Flag("files-with-matches", "只输出包含匹配行的文件").Short('l').Bool()
+
+	serveCommand = app.Command("serve", "启动只读HTTP服务，通过网页或JSON接口浏览版本、下载文件及查看差异")
+	serveListen  = serveCommand.Flag("listen", "监听地址").Default(":8080").String()
+
+	webdavCommand = app.Command("webdav", "启动只读WebDAV服务，以文件夹形式浏览所有版本")
+	webdavListen  = webdavCommand.Flag("listen", "监听地址").Default(":8081").String()
+
+	daemonCommand = app.Command("daemon", "按配置文件中的计划定时执行backup、forget、gc、check")
+	daemonStatus  = daemonCommand.Flag("status", "输出守护进程及各任务的状态").Bool()
+
+	checkCommand        = app.Command("check", "校验备份文件完整性")
+	checkReadDataSubset = checkCommand.Flag("read-data-subset", "只读取部分对象内容进行校验，n/m 为按SHA1分为m份中的第n份，p% 为随机抽取p%").String()
+	checkMetadataOnly   = checkCommand.Flag("metadata-only", "不读取对象内容，只校验对象是否存在及大小").Bool()
 
 	gcCommand = app.Command("gc", "清理备份存储空间，删除残留文件")
+
+	repairCommand      = app.Command("repair", "修复备份数据")
+	repairMetadataOnly = repairCommand.Flag("metadata-only", "不读取对象内容，只修复缺失及大小不符的对象").Bool()
+	repairRebuildIndex = repairCommand.Flag("rebuild-index", "将objects中不在索引中的版本快照重新加入索引").Bool()
+	repairDryRun       = repairCommand.Flag("dry-run", "只输出修复计划，不做任何修改").Bool()
+
+	indexCommand         = app.Command("index", "维护版本索引")
+	indexRebuildCommand  = indexCommand.Command("rebuild", "从objects中的版本快照重建索引")
+	indexRebuildVersions = indexRebuildCommand.Arg("version", "要加入索引的快照SHA1，支持短格式，为空时交互选择").Strings()
+	indexRebuildAll      = indexRebuildCommand.Flag("all", "加入所有找到的版本").Bool()
+	indexRebuildList     = indexRebuildCommand.Flag("list", "只列出不在索引中的版本").Bool()
 )
 
 func main() {
+	// @开头的参数为日期表达式，不作为参数文件展开
+	kingpin.EnableFileExpansion = false
 	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 	mvb.Verbose = *verbose
+	mvb.Format = *format
+	if *jsonOut {
+		mvb.Format = "json"
+	}
+	if mvb.IsStructured() {
+		switch command {
+		case backupCommand.FullCommand(), importCommand.FullCommand(), watchCommand.FullCommand(), restoreCommand.FullCommand(), listCommand.FullCommand(), diffCommand.FullCommand(),
+			previewCommand.FullCommand(), logCommand.FullCommand(), findCommand.FullCommand(), grepCommand.FullCommand(),
+			checkCommand.FullCommand(), gcCommand.FullCommand(),
+			repairCommand.FullCommand(), indexRebuildCommand.FullCommand():
+			mvb.StartRecords()
+		}
+	}
+	defer mvb.Flush()
+
 	switch command {
 	case initCommand.FullCommand():
 		executeInitCommand()
 	case backupCommand.FullCommand():
 		executeBackupCommand()
+	case importCommand.FullCommand():
+		executeImportCommand()
+	case watchCommand.FullCommand():
+		executeWatchCommand()
+	case daemonCommand.FullCommand():
+		executeDaemonCommand()
 	case restoreCommand.FullCommand():
 		executeRestoreCommand()
 	case linkCommand.FullCommand():
@@ -66,16 +174,32 @@ func main() {
 		executeListCommand()
 	case getCommand.FullCommand():
 		executeGetCommand()
+	case exportCommand.FullCommand():
+		executeExportCommand()
 	case deleteCommand.FullCommand():
 		executeDeleteCommand()
 	case diffCommand.FullCommand():
 		executeDiffCommand()
 	case previewCommand.FullCommand():
 		executePreviewCommand()
+	case logCommand.FullCommand():
+		executeLogCommand()
+	case findCommand.FullCommand():
+		executeFindCommand()
+	case grepCommand.FullCommand():
+		executeGrepCommand()
+	case serveCommand.FullCommand():
+		executeServeCommand()
+	case webdavCommand.FullCommand():
+		executeWebDAVCommand()
 	case checkCommand.FullCommand():
 		executeCheckCommand()
 	case gcCommand.FullCommand():
 		executeGcCommand()
+	case repairCommand.FullCommand():
+		executeRepairCommand()
+	case indexRebuildCommand.FullCommand():
+		executeIndexRebuildCommand()
 	}
 }
 
@@ -89,19 +213,136 @@ func executeInitCommand() {
 }
 
 func executeBackupCommand() {
+	unlock := mvb.LockRepository()
+	defer unlock()
+
 	timestamp := time.Now()
-	files := mvb.GetRefFiles()
-	version := mvb.StringifyVersionObject(files)
-	versionSha1 := mvb.Sha1([]byte(version))
+	if *backupStdin {
+		files := mvb.ImportStream(os.Stdin, *backupStdinFilename, timestamp)
+		printVersionSha1(mvb.SaveVersion(files, timestamp))
+		return
+	}
 
+	files := mvb.GetRefFiles()
+	versionSha1 := mvb.Sha1([]byte(mvb.StringifyVersionObject(files)))
 	if !mvb.IsObjectExist(versionSha1) {
 		mvb.CopyObjects(files)
-		mvb.WriteVersionObject(versionSha1, version)
-		mvb.AddVersionToIndex(mvb.Version{Sha1: versionSha1, Timestamp: timestamp.Format(mvb.ISO8601)})
-	} else {
-		mvb.Verbosef("版本已存在： %s\n", versionSha1)
 	}
+	printVersionSha1(mvb.SaveVersion(files, timestamp))
+}
+
+func executeImportCommand() {
+	unlock := mvb.LockRepository()
+	defer unlock()
+
+	timestamp := time.Now()
+	files := mvb.ImportArchive(*importArchive)
+	printVersionSha1(mvb.SaveVersion(files, timestamp))
+}
+
+func executeWatchCommand() {
+	w := mvb.NewWatcher()
+	w.Quiet = *watchQuiet
+	w.MaxInterval = *watchMaxInterval
+	w.OnVersion = func(version mvb.Version) {
+		if mvb.IsStructured() {
+			mvb.Emit(mvb.NewVersionRecord(version, 0))
+			return
+		}
+		mvb.Print(mvb.StringifyVersion(version))
+	}
+
+	// 收到中断或终止信号时，备份尚未备份的变化后退出
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
 
+	if err := w.Run(stop); err != nil {
+		mvb.Errorf("%v", err)
+	}
+}
+
+func executeDaemonCommand() {
+	if *daemonStatus {
+		status, err := mvb.ReadDaemonStatus()
+		if err != nil {
+			mvb.Errorf("%v", err)
+		}
+		if mvb.IsStructured() {
+			mvb.Emit(status)
+			return
+		}
+		mvb.Printf("进程 %d 启动于 %s", status.Pid, formatDaemonTime(&status.Started))
+		if status.Stopped != nil {
+			mvb.Printf("，已停止于 %s", formatDaemonTime(status.Stopped))
+		}
+		mvb.Println()
+		for _, t := range status.Tasks {
+			printTaskStatus(t)
+		}
+		return
+	}
+
+	tasks, err := mvb.LoadDaemonTasks()
+	if err != nil {
+		mvb.Errorf("%v", err)
+	}
+	if len(tasks) == 0 {
+		mvb.Errorf("配置文件%s的[schedule]中没有计划任务", mvb.CONFIG_FILE)
+	}
+
+	d := mvb.NewDaemon(tasks)
+	d.OnTask = func(t mvb.TaskStatus) {
+		if mvb.IsStructured() {
+			mvb.Emit(t)
+			return
+		}
+		printTaskStatus(t)
+	}
+
+	// 收到中断或终止信号时，等待正在执行的任务完成后退出
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	if err := d.Run(stop); err != nil {
+		mvb.Errorf("%v", err)
+	}
+}
+
+func printTaskStatus(t mvb.TaskStatus) {
+	state := "成功"
+	if t.Running {
+		state = "执行中"
+	} else if t.LastRun == nil {
+		state = "未执行"
+	} else if t.LastError != "" {
+		state = "失败：" + t.LastError
+	}
+	mvb.Printf("%-6s %-14s 上次 %-19s 下次 %-19s %s\n", t.Name, t.Schedule, formatDaemonTime(t.LastRun), formatDaemonTime(t.NextRun), state)
+}
+
+func formatDaemonTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// 结构化输出时从索引中读取版本的时间戳，版本已存在时为已有版本的时间戳
+func printVersionSha1(versionSha1 string) {
+	if mvb.IsStructured() {
+		mvb.Emit(mvb.NewVersionRecord(mvb.FindIndexVersion(versionSha1)))
+		return
+	}
 	mvb.Println(versionSha1)
 }
 
@@ -121,30 +362,51 @@ func executeRestoreCommand() {
 		root = mvb.GetRef()
 	}
 
-	src := mvb.GetFiles(root)
-	dst := mvb.GetVersionFiles(version)
-
-	mvb.FastGetFilesSha1(src, dst)
-	mvb.GetFilesSha1(root, src)
-
-	diffFiles := mvb.DiffFiles(src, dst)
-	for i := len(diffFiles) - 1; i>=0;i-- {
-		f := diffFiles[i]
-		p := filepath.Join(root, f.Path)
+	options := mvb.NewRestoreOptions()
+	options.Patterns = append(*restorePaths, *restoreInclude...)
+	options.NoDelete = *restoreNoDel
+	options.Overwrite = *restoreOverwr
+	options.BackupSuffix = *restoreSuffix
+	options.Mode = *restoreMode
 
-		mvb.Verbosef("%s %s\n", f.Type, f.Path)
-		if f.Type == "+" || f.Type == "*" {
-			if !strings.HasSuffix(f.Path, "/") {
-				mvb.CopyFile(mvb.GetObjectPath(f.Sha1), p)
+	files := mvb.GetVersionFiles(version)
+	actions := mvb.PlanRestore(root, files, options)
+	if mvb.IsStructured() || *restoreDryRun {
+		for _, a := range actions {
+			if mvb.IsStructured() {
+				mvb.Emit(mvb.ActionRecord{Type: "restore", Action: a.Action, Path: a.File.Path, Message: a.Message})
+			} else {
+				if a.Message != "" {
+					mvb.Printf("%s %s（%s）\n", restoreActionNames[a.Action], a.File.Path, a.Message)
+				} else {
+					mvb.Printf("%s %s\n", restoreActionNames[a.Action], a.File.Path)
+				}
 			}
-		} else if f.Type == "-" {
-			if err := os.Remove(p); err != nil {
-				mvb.Errorf("删除文件失败：%s", p)
+		}
+	}
+	if *restoreDryRun {
+		return
+	}
+	mvb.ApplyRestore(root, files, actions, options)
+
+	if *restoreVerify {
+		failures := mvb.VerifyRestore(root, files, actions, options)
+		for _, a := range failures {
+			if mvb.IsStructured() {
+				mvb.Emit(mvb.ActionRecord{Type: "restore", Action: a.Action, Path: a.File.Path, Message: a.Message})
+			} else {
+				mvb.Printf("%s %s（%s）\n", restoreActionNames[a.Action], a.File.Path, a.Message)
 			}
 		}
+		if len(failures) > 0 {
+			mvb.Errorf("校验失败：%d个文件与版本不一致\n", len(failures))
+		}
 	}
 }
 
+var restoreActionNames = map[string]string{"create": "新建", "overwrite": "覆盖", "delete": "删除", "metadata": "元数据", "skip": "跳过",
+	"mismatch": "不一致", "missing": "缺失"}
+
 func executeLinkCommand() {
 	version := *linkVersion
 	path := *linkPath
@@ -164,14 +426,8 @@ func executeLinkCommand() {
 			if err := os.Mkdir(filepath.Join(path, f.Path), os.ModeDir|0755); err != nil {
 				mvb.Errorf("%v", err)
 			}
-		} else {
-			file, err := filepath.Abs(mvb.GetObjectPath(f.Sha1))
-			if err != nil {
-				mvb.Errorf("%v", err)
-			}
-			if err := os.Symlink(file, filepath.Join(path, f.Path)); err != nil {
-				mvb.Errorf("%v", err)
-			}
+		} else if err := mvb.LinkObject(f.Sha1, filepath.Join(path, f.Path), *linkMode); err != nil {
+			mvb.Errorf("%v", err)
 		}
 	}
 }
@@ -179,13 +435,12 @@ func executeLinkCommand() {
 func executeListCommand() {
 	pattern := *listVersion
 
-	if pattern == "" {
+	if mvb.IsStructured() {
+		emitIndexVersions(pattern)
+	} else if pattern == "" {
 		mvb.WriteReverseIndexTo(os.Stdout)
-	} else if strings.HasPrefix(pattern, "v") {
-		r := mvb.GetIndexVersionAt(mvb.ParseIndexedVersion(pattern))
-		mvb.Println(r)
 	} else {
-		for _, r := range mvb.FindIndexVersions(pattern) {
+		for _, r := range mvb.ResolveVersions(pattern) {
 			mvb.Println(r)
 		}
 	}
@@ -196,12 +451,24 @@ func executeGetCommand() {
 	path := *getPath
 
 	if version == "" && path == "" {
+		if mvb.IsStructured() {
+			mvb.StartRecords()
+			emitIndexVersions("")
+			return
+		}
 		mvb.WriteReverseIndexTo(os.Stdout)
 		return
 	}
 
 	version = mvb.ResolveVersionSha1(version)
 	if path == "" {
+		if mvb.IsStructured() {
+			mvb.StartRecords()
+			for _, f := range mvb.GetVersionFiles(version) {
+				mvb.Emit(mvb.NewFileRecord(f))
+			}
+			return
+		}
 		mvb.WriteObjectTo(version, os.Stdout)
 		return
 	}
@@ -209,9 +476,16 @@ func executeGetCommand() {
 	files := mvb.GetVersionFiles(version)
 
 	if strings.HasSuffix(path, "/") {
+		if mvb.IsStructured() {
+			mvb.StartRecords()
+		}
 		for _, f := range files {
 			if strings.HasPrefix(f.Path, path) && f.Path != path {
-				mvb.Print(mvb.StringifyFileMetadata(f))
+				if mvb.IsStructured() {
+					mvb.Emit(mvb.NewFileRecord(f))
+				} else {
+					mvb.Print(mvb.StringifyFileMetadata(f))
+				}
 			}
 		}
 		return
@@ -224,20 +498,91 @@ func executeGetCommand() {
 	mvb.WriteObjectTo(file.Sha1, os.Stdout)
 }
 
+func executeExportCommand() {
+	output := *exportOutput
+
+	archiveType := *exportType
+	if archiveType == "" {
+		archiveType = mvb.GuessArchiveType(output)
+	}
+	if archiveType == "" {
+		archiveType = "tar"
+	}
+
+	// 版本范围中的每个版本导出到以时间戳命名的文件夹
+	export := func(w io.Writer) {
+		if strings.Contains(*exportVersion, "..") {
+			var versions []mvb.Version
+			for _, v := range mvb.ResolveVersions(*exportVersion) {
+				versions = append(versions, mvb.ParseVersion(v))
+			}
+			mvb.ExportVersions(w, versions, *exportPrefix, archiveType)
+		} else {
+			version := mvb.ResolveVersionSha1(*exportVersion)
+			mvb.ExportFiles(w, mvb.GetVersionFiles(version), *exportPrefix, archiveType)
+		}
+	}
+	if output == "" {
+		mvb.DataOnStdout = true
+		export(os.Stdout)
+		return
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		mvb.Errorf("%v", err)
+	}
+	export(f)
+	if err := f.Close(); err != nil {
+		mvb.Errorf("%v", err)
+	}
+}
+
 func executeDeleteCommand() {
 	pattern := *deleteVersion
 
-	if strings.HasPrefix(pattern, "v") {
-		mvb.DeleteIndexVersionAt(mvb.ParseIndexedVersion(pattern))
-	} else {
-		mvb.DeleteIndexVersion(pattern)
+	indexes := mvb.ResolveVersionIndexes(pattern)
+	if len(indexes) == 0 {
+		mvb.Errorf("未找到对应的版本：%s", pattern)
 	}
+	if *deleteKeep > 0 {
+		n := mvb.GetIndexVersionCount() - *deleteKeep
+		var r []int
+		for _, i := range indexes {
+			if i < n {
+				r = append(r, i)
+			}
+		}
+		if len(r) == 0 {
+			mvb.Verbosef("没有需要删除的版本，匹配的版本均为最新的%d个版本\n", *deleteKeep)
+			return
+		}
+		indexes = r
+	}
+	// 检查实际匹配的版本而不是参数，..@now、2等同样会匹配所有版本
+	if len(indexes) == mvb.GetIndexVersionCount() && !*deleteForce {
+		mvb.Errorf("为防止误操作，不能删除所有版本：%s，确认删除时使用--force", pattern)
+	}
+	mvb.DeleteIndexVersionsAt(indexes)
 }
 
 func executeDiffCommand() {
 	versionA := *diffVersionA
 	versionB := *diffVersionB
 
+	// 版本范围比较范围内的第一个与最后一个版本
+	if strings.Contains(versionA, "..") {
+		if versionB != "" {
+			mvb.Errorf("指定版本范围时不能再指定版本B")
+		}
+		versions := mvb.ResolveVersions(versionA)
+		if len(versions) == 0 {
+			mvb.Errorf("未找到对应的版本：%s", versionA)
+		}
+		versionA = mvb.ParseVersion(versions[0]).Sha1
+		versionB = mvb.ParseVersion(versions[len(versions)-1]).Sha1
+	}
+
 	if versionA == "" {
 		versionA = mvb.GetLatestVersionSha1()
 		if versionA == "" {
@@ -249,8 +594,9 @@ func executeDiffCommand() {
 	filesA := mvb.GetVersionFiles(versionA)
 
 	var filesB []mvb.FileMetadata
+	root := ""
 	if versionB == "" {
-		root := mvb.GetRef()
+		root = mvb.GetRef()
 		filesB = mvb.GetFiles(root)
 		mvb.GetFilesSha1(root, filesB)
 	} else {
@@ -258,9 +604,110 @@ func executeDiffCommand() {
 		filesB = mvb.GetVersionFiles(versionB)
 	}
 
+	// 比较双方文件内容所在路径
+	pathA := func(f mvb.FileMetadata) string {
+		return mvb.GetObjectPath(f.Sha1)
+	}
+	pathB := func(f mvb.FileMetadata) string {
+		if root != "" {
+			return filepath.Join(root, f.Path)
+		}
+		return mvb.GetObjectPath(f.Sha1)
+	}
+	read := func(path func(mvb.FileMetadata) string) func(mvb.FileMetadata) []byte {
+		return func(f mvb.FileMetadata) []byte {
+			data, err := ioutil.ReadFile(path(f))
+			if err != nil {
+				mvb.Errorf("%v", err)
+			}
+			return data
+		}
+	}
+
 	diffFiles := mvb.DiffFiles(filesA, filesB)
+	if *diffMetadata {
+		diffFiles = append(diffFiles, mvb.DiffModTimes(filesA, filesB)...)
+		sort.Sort(mvb.DiffFileMetadataSlice(diffFiles))
+	}
+	if !*diffNoRename {
+		diffFiles = mvb.DetectRenames(diffFiles, *diffRenames, read(pathA), read(pathB))
+		if !*diffPatch && !*diffStat {
+			diffFiles = mvb.CollapseDirectoryRenames(diffFiles)
+		}
+	}
+
+	var matched []mvb.DiffFileMetadata
 	for _, f := range diffFiles {
-		fmt.Printf("%s %s\n", f.Type, f.Path)
+		if mvb.MatchPaths(*diffPaths, f.Path) || f.Type == "R" && mvb.MatchPaths(*diffPaths, f.OldPath) {
+			matched = append(matched, f)
+		}
+	}
+	diffFiles = matched
+
+	if mvb.IsStructured() {
+		if *diffPatch {
+			mvb.Errorf("--patch 不支持结构化输出")
+		}
+		if *diffStat {
+			for _, s := range mvb.GetDiffStats(diffFiles, filesA) {
+				mvb.Emit(mvb.NewDiffStatRecord(s))
+			}
+			return
+		}
+		for _, f := range diffFiles {
+			mvb.Emit(mvb.NewDiffRecord(f))
+		}
+		return
+	}
+
+	if *diffStat {
+		var total mvb.DiffStat
+		fmt.Println("  新增   删除   修改 重命名 元数据       大小变化 文件夹")
+		for _, s := range mvb.GetDiffStats(diffFiles, filesA) {
+			dir := s.Dir
+			if dir == "" {
+				dir = "./"
+			}
+			fmt.Printf("%6d %6d %6d %6d %6d %+14d %s\n", s.Added, s.Removed, s.Modified, s.Renamed, s.Metadata, s.Bytes, dir)
+			total.Added += s.Added
+			total.Removed += s.Removed
+			total.Modified += s.Modified
+			total.Renamed += s.Renamed
+			total.Metadata += s.Metadata
+			total.Bytes += s.Bytes
+		}
+		fmt.Printf("%6d %6d %6d %6d %6d %+14d %s\n", total.Added, total.Removed, total.Modified, total.Renamed, total.Metadata, total.Bytes, "合计")
+		if !*diffPatch {
+			return
+		}
+	}
+
+	for _, f := range diffFiles {
+		if !*diffPatch {
+			if f.Type == "R" && f.Similarity < 100 {
+				fmt.Printf("%s %s -> %s (%d%%)\n", f.Type, f.OldPath, f.Path, f.Similarity)
+			} else if f.Type == "R" {
+				fmt.Printf("%s %s -> %s\n", f.Type, f.OldPath, f.Path)
+			} else {
+				fmt.Printf("%s %s\n", f.Type, f.Path)
+			}
+			continue
+		}
+		if strings.HasSuffix(f.Path, "/") || f.Type == "M" {
+			continue
+		}
+
+		oldPath, fileA, fileB := f.Path, "", ""
+		if f.Type == "R" {
+			oldPath = f.OldPath
+		}
+		if a := mvb.SearchFile(filesA, oldPath); a != nil && f.Type != "+" {
+			fileA = pathA(*a)
+		}
+		if f.Type != "-" {
+			fileB = pathB(f.FileMetadata)
+		}
+		mvb.WritePatchTo(os.Stdout, oldPath, f.Path, fileA, fileB, *diffContext)
 	}
 }
 
@@ -269,43 +716,245 @@ func executePreviewCommand() {
 	version := mvb.StringifyVersionObject(files)
 	versionSha1 := mvb.Sha1([]byte(version))
 
+	if mvb.IsStructured() {
+		for _, f := range files {
+			mvb.Emit(mvb.NewFileRecord(f))
+		}
+		mvb.Emit(mvb.NewVersionRecord(mvb.Version{Sha1: versionSha1}, 0))
+		return
+	}
 	mvb.Println(version)
 	mvb.Println(versionSha1)
 }
 
-func executeCheckCommand() {
-	var wg sync.WaitGroup
-	sem := make(chan int, mvb.MAX_GOS)
-	filepath.Walk("objects", func(path string, fi os.FileInfo, err error) error {
+func executeLogCommand() {
+	path := *logPath
+	dir := strings.HasSuffix(path, "/")
+
+	history := mvb.GetPathHistory(path, *logFollow)
+	if !*logReverse {
+		for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+			history[i], history[j] = history[j], history[i]
+		}
+	}
+
+	for _, h := range history {
+		var stat mvb.DiffStat
+		if dir {
+			for _, s := range mvb.GetDiffStats(h.Changes, h.Before) {
+				stat.Added += s.Added
+				stat.Removed += s.Removed
+				stat.Modified += s.Modified
+				stat.Renamed += s.Renamed
+				stat.Bytes += s.Bytes
+			}
+		}
+
+		if mvb.IsStructured() {
+			r := mvb.LogRecord{Type: "log", Version: mvb.NewVersionRecord(h.Version, 0)}
+			if dir {
+				s := mvb.NewDiffStatRecord(stat)
+				s.Dir = path
+				r.Stat = &s
+			}
+			if !dir || *logNameStatus {
+				for _, c := range h.Changes {
+					r.Changes = append(r.Changes, mvb.NewDiffRecord(c))
+				}
+			}
+			mvb.Emit(r)
+			continue
+		}
+
+		if !dir {
+			c := h.Changes[0]
+			f := c.FileMetadata
+			if c.Type == "R" {
+				f.Path = c.OldPath + " -> " + c.Path
+			}
+			mvb.Printf("%s %s %s %s", c.Type, h.Sha1, h.Timestamp, mvb.StringifyFileMetadata(f))
+			continue
+		}
+
+		mvb.Printf("%s %s +%d -%d *%d R%d\n", h.Sha1, h.Timestamp, stat.Added, stat.Removed, stat.Modified, stat.Renamed)
+		if *logNameStatus {
+			for _, c := range h.Changes {
+				if c.Type == "R" {
+					mvb.Printf("  %s %s -> %s\n", c.Type, c.OldPath, c.Path)
+				} else {
+					mvb.Printf("  %s %s\n", c.Type, c.Path)
+				}
+			}
+		}
+	}
+}
+
+func executeFindCommand() {
+	filter := mvb.NewFileFilter()
+	filter.Patterns = *findPatterns
+	filter.Sha1 = *findSha1
+	if *findNewer != "" {
+		t, err := mvb.ParseTime(*findNewer)
 		if err != nil {
 			mvb.Errorf("%v", err)
 		}
-		if fi.IsDir() {
-			return nil
-		}
-		p, err := filepath.Rel("objects", path)
+		filter.Newer = t
+	}
+	if *findOlder != "" {
+		t, err := mvb.ParseTime(*findOlder)
 		if err != nil {
 			mvb.Errorf("%v", err)
 		}
+		filter.Older = t
+	}
+	if *findSize != "" {
+		if err := filter.ParseSize(*findSize); err != nil {
+			mvb.Errorf("%v", err)
+		}
+	}
 
-		sem <- 1
-		wg.Add(1)
-		go func() {
-			s1 := p[:2] + p[3:]
-			s2 := mvb.GetFileSha1(path)
-			mvb.Verbosef("检查：%s\n", path)
-			if s1 != s2 {
-				mvb.Println(path)
+	var versions []mvb.Version
+	if *findVersion == "" {
+		for _, v := range mvb.GetIndexVersions() {
+			versions = append(versions, mvb.ParseVersion(v))
+		}
+	} else {
+		for _, v := range mvb.ResolveVersions(*findVersion) {
+			versions = append(versions, mvb.ParseVersion(v))
+		}
+	}
+
+	for _, v := range mvb.FindFiles(versions, filter) {
+		for _, f := range v.Files {
+			if mvb.IsStructured() {
+				mvb.Emit(mvb.FindRecord{Type: "find", Version: mvb.NewVersionRecord(v.Version, 0), File: mvb.NewFileRecord(f)})
+			} else {
+				mvb.Printf("%s %s %s", v.Sha1, v.Timestamp, mvb.StringifyFileMetadata(f))
 			}
-			wg.Done()
-			<-sem
-		}()
-		return nil
-	})
-	wg.Wait()
+		}
+	}
+}
+
+func executeGrepCommand() {
+	pattern := *grepPattern
+	if *grepIgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		mvb.Errorf("%v", err)
+	}
+
+	var versions []mvb.Version
+	if *grepVersion == "" {
+		for _, v := range mvb.GetIndexVersions() {
+			versions = append(versions, mvb.ParseVersion(v))
+		}
+	} else {
+		for _, v := range mvb.ResolveVersions(*grepVersion) {
+			versions = append(versions, mvb.ParseVersion(v))
+		}
+	}
+
+	for _, m := range mvb.Grep(versions, *grepPrefix, re) {
+		if *grepFilesOnly {
+			if mvb.IsStructured() {
+				mvb.Emit(mvb.GrepRecord{Type: "grep", Version: mvb.NewVersionRecord(m.Version, 0), Path: m.File.Path, Sha1: m.File.Sha1})
+			} else {
+				mvb.Printf("%s %s %s\n", m.Sha1, m.Timestamp, m.File.Path)
+			}
+			continue
+		}
+		for _, l := range m.Lines {
+			if mvb.IsStructured() {
+				mvb.Emit(mvb.GrepRecord{Type: "grep", Version: mvb.NewVersionRecord(m.Version, 0), Path: m.File.Path, Sha1: m.File.Sha1, Line: l.Line, Text: l.Text})
+			} else {
+				mvb.Printf("%s %s %s:%d:%s\n", m.Sha1, m.Timestamp, m.File.Path, l.Line, l.Text)
+			}
+		}
+	}
+}
+
+func executeServeCommand() {
+	fmt.Fprintf(os.Stderr, "监听：%s\n", *serveListen)
+	if err := http.ListenAndServe(*serveListen, mvb.NewServer()); err != nil {
+		mvb.Errorf("%v", err)
+	}
+}
+
+func executeWebDAVCommand() {
+	fmt.Fprintf(os.Stderr, "监听：%s\n", *webdavListen)
+	if err := http.ListenAndServe(*webdavListen, mvb.NewWebDAVHandler()); err != nil {
+		mvb.Errorf("%v", err)
+	}
+}
+
+func executeCheckCommand() {
+	var read func(string) bool
+	if *checkMetadataOnly {
+		read = func(string) bool { return false }
+	} else if *checkReadDataSubset != "" {
+		var err error
+		if read, err = mvb.ParseDataSubset(*checkReadDataSubset); err != nil {
+			mvb.Errorf("%v", err)
+		}
+	}
+
+	r := mvb.Check(read)
+	mvb.Verbosef("读取对象：%d/%d\n", r.ReadObjects, r.Objects)
+
+	if mvb.IsStructured() {
+		for _, e := range r.IndexErrors {
+			mvb.Emit(mvb.CheckRecord{Type: "check_index", Message: e})
+		}
+		for _, p := range r.BadObjects {
+			mvb.Emit(mvb.CheckRecord{Type: "check_object", Path: p})
+		}
+		for _, h := range r.Versions {
+			mvb.Emit(mvb.NewVersionHealthRecord(h))
+		}
+		if !r.OK() {
+			mvb.Errorf("校验失败\n")
+		}
+		return
+	}
+
+	for _, e := range r.IndexErrors {
+		mvb.Printf("索引错误：%s\n", e)
+	}
+	for _, p := range r.BadObjects {
+		mvb.Printf("对象损坏：%s\n", p)
+	}
+	for _, h := range r.Versions {
+		if h.OK() {
+			mvb.Printf("%s %s 正常 %d\n", h.Sha1, h.Timestamp, h.Files)
+			continue
+		}
+		mvb.Printf("%s %s 错误 %d\n", h.Sha1, h.Timestamp, h.Files)
+		if h.Error != "" {
+			mvb.Printf("  %s\n", h.Error)
+		}
+		for _, f := range h.Missing {
+			mvb.Printf("  缺失 %s %s\n", f.Sha1, f.Path)
+		}
+		for _, f := range h.Corrupt {
+			mvb.Printf("  损坏 %s %s\n", f.Sha1, f.Path)
+		}
+		for _, f := range h.Mismatch {
+			mvb.Printf("  大小不符 %s %s\n", f.Sha1, f.Path)
+		}
+	}
+
+	if !r.OK() {
+		mvb.Errorf("校验失败\n")
+	}
 }
 
 func executeGcCommand() {
+	// 等待正在进行的备份完成，避免删除新保存但尚未被版本引用的对象
+	unlock := mvb.LockRepository()
+	defer unlock()
+
 	objects := map[string]bool{}
 
 	for _, v := range mvb.GetIndexVersions() {
@@ -327,11 +976,18 @@ func executeGcCommand() {
 		if err != nil {
 			mvb.Errorf("%v", err)
 		}
-
+		if mvb.IsObjectTempFile(p) {
+			mvb.Verbosef("跳过临时文件：%s\n", path)
+			return nil
+		}
 
 		s := p[:2] + p[3:]
 		if _, ok := objects[s]; !ok {
-			mvb.Println(s)
+			if mvb.IsStructured() {
+				mvb.Emit(mvb.ActionRecord{Type: "gc", Action: "delete", Object: s, Path: path})
+			} else {
+				mvb.Println(s)
+			}
 			mvb.Verbosef("删除：%s\n", path)
 			if err := os.Remove(path); err != nil {
 				mvb.Errorf("%v", err)
@@ -361,4 +1017,219 @@ func executeGcCommand() {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+func executeRepairCommand() {
+	var read func(string) bool
+	if *repairMetadataOnly {
+		read = func(string) bool { return false }
+	}
+	r := mvb.Check(read)
+
+	// 需要修复的对象，及引用它们的版本与路径
+	affected := map[string][]mvb.ActionRecord{}
+	broken := map[string]bool{}
+	for _, h := range r.Versions {
+		if h.Error != "" {
+			broken[h.Sha1] = true
+			affected[h.Sha1] = append(affected[h.Sha1], mvb.ActionRecord{Type: "repair", Action: "unfixable",
+				Object: h.Sha1, Version: mvb.NewVersionRecord(h.Version, 0), Message: h.Error})
+		}
+		for _, files := range [][]mvb.FileMetadata{h.Missing, h.Corrupt, h.Mismatch} {
+			for _, f := range files {
+				broken[f.Sha1] = true
+				affected[f.Sha1] = append(affected[f.Sha1], mvb.ActionRecord{Type: "repair", Action: "unfixable",
+					Object: f.Sha1, Version: mvb.NewVersionRecord(h.Version, 0), Path: f.Path})
+			}
+		}
+	}
+
+	for s, src := range mvb.FindRefObjects(broken) {
+		if *repairDryRun || mvb.RepairObject(s, src) {
+			if mvb.IsStructured() {
+				mvb.Emit(mvb.ActionRecord{Type: "repair", Action: "resupply", Object: s, Path: src})
+			} else {
+				mvb.Printf("恢复对象：%s %s\n", s, src)
+			}
+			delete(broken, s)
+		}
+	}
+
+	changed := len(r.IndexErrors) > 0
+	var versions []mvb.Version
+	for _, h := range r.Versions {
+		if broken[h.Sha1] {
+			if mvb.IsStructured() {
+				mvb.Emit(mvb.ActionRecord{Type: "repair", Action: "drop", Version: mvb.NewVersionRecord(h.Version, 0)})
+			} else {
+				mvb.Printf("删除版本：%s %s\n", h.Sha1, h.Timestamp)
+			}
+			changed = true
+			continue
+		}
+		versions = append(versions, h.Version)
+	}
+	if *repairRebuildIndex {
+		indexed := map[string]bool{}
+		for _, v := range r.Versions {
+			indexed[v.Sha1] = true
+		}
+		for _, v := range mvb.FindSnapshotObjects() {
+			if !indexed[v.Sha1] {
+				if mvb.IsStructured() {
+					mvb.Emit(mvb.ActionRecord{Type: "repair", Action: "add", Version: mvb.NewVersionRecord(v, 0)})
+				} else {
+					mvb.Printf("添加版本：%s %s\n", v.Sha1, v.Timestamp)
+				}
+				versions = append(versions, v)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		mvb.SortVersions(versions)
+		if !*repairDryRun {
+			mvb.WriteIndex(versions)
+		}
+	}
+
+	var unfixed []mvb.ActionRecord
+	for s := range broken {
+		unfixed = append(unfixed, affected[s]...)
+	}
+	sort.Slice(unfixed, func(i, j int) bool {
+		a, b := unfixed[i], unfixed[j]
+		return a.Object < b.Object || a.Object == b.Object && a.Version.Sha1+a.Path < b.Version.Sha1+b.Path
+	})
+	for _, u := range unfixed {
+		if mvb.IsStructured() {
+			mvb.Emit(u)
+		} else {
+			mvb.Printf("无法修复：%s %s %s %s%s\n", u.Object, u.Version.Sha1, u.Version.Timestamp.Format(mvb.ISO8601), u.Path, u.Message)
+		}
+	}
+	if len(unfixed) > 0 {
+		mvb.Errorf("修复未完成\n")
+	}
+}
+
+func executeIndexRebuildCommand() {
+	versions, errors := mvb.CheckIndex()
+	if len(errors) > 0 {
+		mvb.Errorf("索引文件有错误，请先执行 mvb repair：%s", errors[0])
+	}
+	indexed := map[string]bool{}
+	for _, v := range versions {
+		indexed[v.Sha1] = true
+	}
+
+	var found []mvb.Version
+	for _, v := range mvb.FindSnapshotObjects() {
+		if !indexed[v.Sha1] {
+			found = append(found, v)
+		}
+	}
+	if len(found) == 0 {
+		mvb.Verbosef("没有不在索引中的版本\n")
+		return
+	}
+
+	// --list只列出版本，与--all或指定版本同时使用时也不修改索引
+	var selected []mvb.Version
+	if *indexRebuildList || (!*indexRebuildAll && len(*indexRebuildVersions) == 0) {
+		for i, v := range found {
+			n := len(mvb.GetVersionFiles(v.Sha1))
+			if mvb.IsStructured() {
+				r := mvb.NewVersionRecord(v, i+1)
+				r.Files = &n
+				mvb.Emit(r)
+			} else {
+				mvb.Printf("%3d %s %s %d\n", i+1, v.Sha1, v.Timestamp, n)
+			}
+		}
+		if *indexRebuildList || mvb.IsStructured() {
+			return
+		}
+
+		mvb.Printf("请输入要加入索引的版本编号（如 1,3-5，all 为全部，直接回车取消）：")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return
+		}
+		if line == "all" {
+			selected = found
+		} else {
+			for _, i := range parseNumberList(line, len(found)) {
+				selected = append(selected, found[i-1])
+			}
+		}
+	} else if *indexRebuildAll {
+		selected = found
+	} else {
+		for _, pattern := range *indexRebuildVersions {
+			var matched []mvb.Version
+			for _, v := range found {
+				if strings.HasPrefix(v.Sha1, pattern) {
+					matched = append(matched, v)
+				}
+			}
+			if len(matched) == 0 {
+				mvb.Errorf("未找到对应的版本：%s", pattern)
+			}
+			if len(matched) > 1 {
+				mvb.Errorf("找到多个版本，请输入更精确的版本号：%s", pattern)
+			}
+			selected = append(selected, matched[0])
+		}
+	}
+
+	for _, v := range selected {
+		if mvb.IsStructured() {
+			mvb.Emit(mvb.ActionRecord{Type: "index_rebuild", Action: "add", Version: mvb.NewVersionRecord(v, 0)})
+		} else {
+			mvb.Printf("添加版本：%s %s\n", v.Sha1, v.Timestamp)
+		}
+	}
+	versions = append(versions, selected...)
+	mvb.SortVersions(versions)
+	mvb.WriteIndex(versions)
+}
+
+// 解析形如 1,3-5 的编号列表，编号范围为1到max
+func parseNumberList(text string, max int) (r []int) {
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		from, to := part, part
+		if i := strings.Index(part, "-"); i > 0 {
+			from, to = part[:i], part[i+1:]
+		}
+		a, err1 := strconv.Atoi(strings.TrimSpace(from))
+		b, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 != nil || err2 != nil || a < 1 || b > max || a > b {
+			mvb.Errorf("编号错误：%s", part)
+		}
+		for i := a; i <= b; i++ {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+// 结构化输出匹配的版本，顺序与文本格式相同
+func emitIndexVersions(pattern string) {
+	var versions []mvb.Version
+	for _, v := range mvb.GetIndexVersions() {
+		versions = append(versions, mvb.ParseVersion(v))
+	}
+
+	if pattern == "" {
+		for i := len(versions) - 1; i >= 0; i-- {
+			mvb.Emit(mvb.NewVersionRecord(versions[i], i+1))
+		}
+	} else {
+		for _, i := range mvb.ResolveVersionIndexes(pattern) {
+			mvb.Emit(mvb.NewVersionRecord(versions[i], i+1))
+		}
+	}
+}
@@ -1,12 +1,21 @@
 package mvb
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 )
 
 var Verbose bool
 
+// 输出格式：text、json、ndjson
+var Format = "text"
+
+// 标准输出用于输出数据（如导出归档到标准输出）时为true，调试信息改为输出到标准错误
+var DataOnStdout bool
+
+var records []interface{}
+
 func Print(a ...interface{})  {
 	fmt.Fprint(os.Stdout, a...)
 }
@@ -20,12 +29,55 @@ func Printf(format string, a ...interface{})  {
 }
 
 func Errorf(format string, a ...interface{}) {
+	Flush()
 	fmt.Fprintf(os.Stderr, format, a...)
 	os.Exit(1)
 }
 
+// 结构化输出时，调试信息输出到标准错误，避免混入记录
 func Verbosef(format string, a ...interface{}) {
 	if Verbose {
-		fmt.Fprintf(os.Stdout, format, a...)
+		if IsStructured() || DataOnStdout {
+			fmt.Fprintf(os.Stderr, format, a...)
+		} else {
+			fmt.Fprintf(os.Stdout, format, a...)
+		}
+	}
+}
+
+func IsStructured() bool {
+	return Format == "json" || Format == "ndjson"
+}
+
+// 输出结构化记录。ndjson格式每条记录输出一行；json格式先缓存，由Flush输出为数组
+func Emit(record interface{}) {
+	if Format == "ndjson" {
+		if err := json.NewEncoder(os.Stdout).Encode(record); err != nil {
+			fmt.Fprintf(os.Stderr, "Emit: %v", err)
+			os.Exit(1)
+		}
+		return
 	}
-}
\ No newline at end of file
+	records = append(records, record)
+}
+
+// 开始结构化输出，json格式时即使没有任何记录也会输出空数组
+func StartRecords() {
+	if records == nil {
+		records = []interface{}{}
+	}
+}
+
+func Flush() {
+	if Format != "json" || records == nil {
+		return
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Flush: %v", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(data)
+	os.Stdout.WriteString("\n")
+	records = nil
+}
@@ -0,0 +1,337 @@
+package mvb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ObjectHealth struct {
+	Size    int64
+	Read    bool // 是否读取内容计算了SHA1
+	Corrupt bool
+}
+
+type VersionHealth struct {
+	Version
+	Files    int            // 快照中文件及文件夹数量
+	Error    string         // 快照本身的错误（缺失、损坏、格式错误），为空表示快照正常
+	Missing  []FileMetadata // 对象缺失的文件
+	Corrupt  []FileMetadata // 对象损坏的文件
+	Mismatch []FileMetadata // 对象大小与快照记录不符的文件
+}
+
+func (h *VersionHealth) OK() bool {
+	return h.Error == "" && len(h.Missing) == 0 && len(h.Corrupt) == 0 && len(h.Mismatch) == 0
+}
+
+type CheckReport struct {
+	IndexErrors []string // 索引记录错误
+	BadObjects  []string // 内容与SHA1不符或命名不合法的对象路径
+	Objects     int      // 对象总数
+	ReadObjects int      // 读取内容校验的对象数
+	Versions    []VersionHealth
+}
+
+func (r *CheckReport) OK() bool {
+	if len(r.IndexErrors) > 0 || len(r.BadObjects) > 0 {
+		return false
+	}
+	for i := range r.Versions {
+		if !r.Versions[i].OK() {
+			return false
+		}
+	}
+	return true
+}
+
+func IsSha1(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func CheckVersionRecord(text string) error {
+	if len(text) != VERSION_LEN-1 {
+		return fmt.Errorf("记录长度错误：%d", len(text))
+	}
+	if !IsSha1(text[:40]) || text[40] != ' ' {
+		return fmt.Errorf("快照SHA1格式错误：%q", text[:40])
+	}
+	if _, err := time.Parse(ISO8601, text[41:]); err != nil {
+		return fmt.Errorf("时间戳格式错误：%q", text[41:])
+	}
+	return nil
+}
+
+func CheckFileMetadataRecord(text string) error {
+	if len(text) < 82 || text[40] != ' ' || text[60] != ' ' || text[80] != ' ' {
+		return fmt.Errorf("记录格式错误：%q", text)
+	}
+	f := ParseFileMetadata(text)
+	if err := CheckSnapshotPath(f.Path); err != nil {
+		return err
+	}
+	if _, err := time.Parse(ISO8601, f.ModTime); err != nil {
+		return fmt.Errorf("时间戳格式错误：%s", f.Path)
+	}
+	if strings.HasSuffix(f.Path, "/") {
+		if f.Sha1 != EMPTY_SHA1 || f.Size != EMPTY_SIZE {
+			return fmt.Errorf("文件夹不应有SHA1及大小：%s", f.Path)
+		}
+		return nil
+	}
+	if !IsSha1(f.Sha1) {
+		return fmt.Errorf("文件SHA1格式错误：%s", f.Path)
+	}
+	size := strings.TrimLeft(f.Size, " ")
+	if n, err := strconv.ParseInt(size, 10, 64); err != nil || n < 0 || strconv.FormatInt(n, 10) != size {
+		return fmt.Errorf("文件大小格式错误：%s", f.Path)
+	}
+	return nil
+}
+
+// 校验快照中的路径：不能为绝对路径，不能包含NUL、空的路径部分、.或..，文件夹以一个/结尾
+func CheckSnapshotPath(path string) error {
+	p := strings.TrimSuffix(path, "/")
+	if p == "" || strings.HasPrefix(p, "/") || filepath.VolumeName(p) != "" {
+		return fmt.Errorf("路径不合法：%q", path)
+	}
+	if strings.IndexByte(p, 0) >= 0 {
+		return fmt.Errorf("路径包含NUL：%q", path)
+	}
+	if strings.IndexByte(p, '\n') >= 0 {
+		return fmt.Errorf("路径包含换行：%q", path)
+	}
+	for _, s := range strings.Split(p, "/") {
+		if s == "" || s == "." || s == ".." {
+			return fmt.Errorf("路径不合法：%q", path)
+		}
+	}
+	return nil
+}
+
+// 严格解析版本快照，校验每行格式及路径排序
+func CheckVersionObject(o string) ([]FileMetadata, error) {
+	var files []FileMetadata
+	if len(o) == 0 {
+		return files, nil
+	}
+	if !strings.HasSuffix(o, "\n") {
+		return nil, fmt.Errorf("快照未以换行结尾")
+	}
+	for i, line := range strings.Split(o[:len(o)-1], "\n") {
+		if err := CheckFileMetadataRecord(line); err != nil {
+			return nil, fmt.Errorf("第%d行：%v", i+1, err)
+		}
+		f := ParseFileMetadata(line)
+		if n := len(files); n > 0 && files[n-1].Path >= f.Path {
+			return nil, fmt.Errorf("第%d行：路径未排序或重复：%s", i+1, f.Path)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// 校验索引文件，返回所有格式正确的版本
+func CheckIndex() (versions []Version, errors []string) {
+	data, err := ioutil.ReadFile("index")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return versions, errors
+		}
+		Errorf("CheckIndex: %v", err)
+	}
+
+	if len(data)%VERSION_LEN != 0 {
+		errors = append(errors, fmt.Sprintf("索引文件大小不是记录长度的整数倍：%d", len(data)))
+	}
+	var last time.Time
+	for i := 0; i+VERSION_LEN <= len(data); i += VERSION_LEN {
+		n := i/VERSION_LEN + 1
+		record := string(data[i : i+VERSION_LEN])
+		if !strings.HasSuffix(record, "\n") {
+			errors = append(errors, fmt.Sprintf("第%d条记录：未以换行结尾", n))
+			continue
+		}
+		record = record[:VERSION_LEN-1]
+		if err := CheckVersionRecord(record); err != nil {
+			errors = append(errors, fmt.Sprintf("第%d条记录：%v", n, err))
+			continue
+		}
+		v := ParseVersion(record)
+		t, _ := time.Parse(ISO8601, v.Timestamp)
+		if t.Before(last) {
+			errors = append(errors, fmt.Sprintf("第%d条记录：时间戳早于上一版本：%s", n, v.Timestamp))
+		}
+		last = t
+		versions = append(versions, v)
+	}
+	return versions, errors
+}
+
+// 解析数据子集，n/m 表示按SHA1分为m份中的第n份，p% 表示随机抽取p%的对象
+func ParseDataSubset(subset string) (func(objectSha1 string) bool, error) {
+	if strings.HasSuffix(subset, "%") {
+		p, err := strconv.ParseFloat(subset[:len(subset)-1], 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("数据子集格式错误：%s", subset)
+		}
+		return func(string) bool {
+			return rand.Float64()*100 < p
+		}, nil
+	}
+
+	i := strings.Index(subset, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("数据子集格式错误：%s", subset)
+	}
+	n, err1 := strconv.Atoi(subset[:i])
+	m, err2 := strconv.Atoi(subset[i+1:])
+	if err1 != nil || err2 != nil || m < 1 || m > 0x10000 || n < 1 || n > m {
+		return nil, fmt.Errorf("数据子集格式错误：%s", subset)
+	}
+	return func(objectSha1 string) bool {
+		k, _ := strconv.ParseUint(objectSha1[:4], 16, 32)
+		return int(k)%m == n-1
+	}, nil
+}
+
+// 检查objects中所有对象，read为nil时读取所有对象，否则只读取read返回true的对象重新计算SHA1，
+// 返回所有对象的状态，及所有损坏或命名不合法的对象路径。保存对象时的临时文件不检查
+func CheckObjects(read func(objectSha1 string) bool) (objects map[string]*ObjectHealth, bad []string) {
+	objects = map[string]*ObjectHealth{}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan int, MAX_GOS)
+	err := filepath.Walk("objects", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		p, err := filepath.Rel("objects", path)
+		if err != nil {
+			return err
+		}
+		p = filepath.ToSlash(p)
+		if IsObjectTempFile(p) {
+			return nil
+		}
+		if len(p) != 41 || p[2] != '/' || !IsSha1(p[:2]+p[3:]) {
+			mu.Lock()
+			bad = append(bad, path)
+			mu.Unlock()
+			return nil
+		}
+
+		s1 := p[:2] + p[3:]
+		o := &ObjectHealth{Size: fi.Size()}
+		mu.Lock()
+		objects[s1] = o
+		mu.Unlock()
+		if read != nil && !read(s1) {
+			return nil
+		}
+
+		sem <- 1
+		wg.Add(1)
+		go func() {
+			s2 := GetFileSha1(path)
+			Verbosef("检查：%s\n", path)
+			mu.Lock()
+			o.Read = true
+			o.Corrupt = s1 != s2
+			if o.Corrupt {
+				bad = append(bad, path)
+			}
+			mu.Unlock()
+			wg.Done()
+			<-sem
+		}()
+		return nil
+	})
+	wg.Wait()
+	if err != nil && !os.IsNotExist(err) {
+		Errorf("CheckObjects: %v", err)
+	}
+	sort.Strings(bad)
+	return objects, bad
+}
+
+// 校验版本快照及其引用的所有对象，objects为CheckObjects的结果
+// 快照总是会被读取，因此无论是否在数据子集中都会校验其SHA1
+func CheckVersion(version Version, objects map[string]*ObjectHealth) VersionHealth {
+	h := VersionHealth{Version: version}
+
+	if _, exist := objects[version.Sha1]; !exist {
+		h.Error = "快照缺失"
+		return h
+	}
+	data, err := ioutil.ReadFile(GetObjectPath(version.Sha1))
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	files, err := CheckVersionObject(string(data))
+	if err != nil {
+		h.Error = fmt.Sprintf("快照格式错误：%v", err)
+		return h
+	}
+	if Sha1(data) != version.Sha1 {
+		h.Error = "快照损坏"
+	}
+
+	h.Files = len(files)
+	for _, f := range files {
+		if strings.HasSuffix(f.Path, "/") {
+			continue
+		}
+		o, exist := objects[f.Sha1]
+		if !exist {
+			h.Missing = append(h.Missing, f)
+		} else if o.Corrupt {
+			h.Corrupt = append(h.Corrupt, f)
+		} else if ParseSize(f.Size) != o.Size {
+			h.Mismatch = append(h.Mismatch, f)
+		}
+	}
+	return h
+}
+
+// read为nil时读取所有对象内容，参见CheckObjects
+func Check(read func(objectSha1 string) bool) CheckReport {
+	var r CheckReport
+
+	versions, errors := CheckIndex()
+	r.IndexErrors = errors
+
+	objects, bad := CheckObjects(read)
+	r.BadObjects = bad
+	r.Objects = len(objects)
+	for _, o := range objects {
+		if o.Read {
+			r.ReadObjects++
+		}
+	}
+
+	for _, v := range versions {
+		r.Versions = append(r.Versions, CheckVersion(v, objects))
+	}
+	return r
+}
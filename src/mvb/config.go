@@ -0,0 +1,60 @@
+package mvb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 备份存储空间中的配置文件，格式为：
+//
+//	[section]
+//	key = value
+//
+// 以#或;开头的行为注释
+const CONFIG_FILE = "config"
+
+type Config map[string]map[string]string
+
+// 读取配置文件，文件不存在时返回空配置
+func ReadConfig() (Config, error) {
+	config := Config{}
+	f, err := os.Open(CONFIG_FILE)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 || section == "" {
+			return nil, fmt.Errorf("配置文件格式错误：%s：第%d行", CONFIG_FILE, n)
+		}
+		if config[section] == nil {
+			config[section] = map[string]string{}
+		}
+		config[section][strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c Config) Get(section string, key string) string {
+	return c[section][key]
+}
@@ -11,6 +11,8 @@ import (
 	"path/filepath"
 	"strings"
 	"sort"
+	"strconv"
+	"time"
 )
 
 const MAX_GOS = 4
@@ -34,7 +36,9 @@ type FileMetadata struct {
 
 type DiffFileMetadata struct {
 	FileMetadata
-	Type string
+	Type       string
+	OldPath    string // 重命名前的路径，仅Type为R时有效
+	Similarity int    // 重命名前后内容相似度百分比，仅Type为R时有效
 }
 
 type FileMetadataSlice []FileMetadata
@@ -107,12 +111,7 @@ func GetFiles(root string) []FileMetadata {
 		}
 		p = filepath.ToSlash(p)
 
-		if fi.IsDir() {
-			p = p + "/"
-			files = append(files, FileMetadata{Path: p, ModTime: fi.ModTime().Format(ISO8601), Size: EMPTY_SIZE, Sha1: EMPTY_SHA1})
-		} else {
-			files = append(files, FileMetadata{Path: p, ModTime: fi.ModTime().Format(ISO8601), Size:fmt.Sprintf("%19d", fi.Size())})
-		}
+		files = append(files, NewFileMetadata(p, fi))
 
 		return nil
 	})
@@ -120,6 +119,14 @@ func GetFiles(root string) []FileMetadata {
 	return files
 }
 
+// 根据文件信息生成快照记录，文件夹路径以/结尾，文件的SHA1为空，需另外计算
+func NewFileMetadata(p string, fi os.FileInfo) FileMetadata {
+	if fi.IsDir() {
+		return FileMetadata{Path: p + "/", ModTime: fi.ModTime().Format(ISO8601), Size: EMPTY_SIZE, Sha1: EMPTY_SHA1}
+	}
+	return FileMetadata{Path: p, ModTime: fi.ModTime().Format(ISO8601), Size: fmt.Sprintf("%19d", fi.Size())}
+}
+
 func GetRefFiles() []FileMetadata {
 	root := GetRef()
 	files := GetFiles(root)
@@ -163,6 +170,95 @@ func StringifyFileMetadata(file FileMetadata) string {
 	return fmt.Sprintf("%40s %19s %19s %s\n", file.Sha1, file.ModTime, file.Size, file.Path)
 }
 
+// 解析时间，支持快照时间戳格式、RFC 3339格式，及本地时间 2006-01-02、2006-01-02T15:04、2006-01-02T15:04:05；
+// 也支持相对当前时间的 now、today、yesterday，及 -30m、-12h、-3d、-2w 等
+func ParseTime(text string) (time.Time, error) {
+	if t, ok := parseRelativeTime(text, time.Now()); ok {
+		return t, nil
+	}
+	if t, err := time.Parse(ISO8601, text); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("时间格式错误：%s", text)
+}
+
+// today、yesterday为当天、前一天的0点，-N[smhdw]为N秒、分、时、天、周之前
+func parseRelativeTime(text string, now time.Time) (time.Time, bool) {
+	switch text {
+	case "now":
+		return now, true
+	case "today":
+		y, m, d := now.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), true
+	case "yesterday":
+		y, m, d := now.Date()
+		return time.Date(y, m, d-1, 0, 0, 0, 0, now.Location()), true
+	}
+	if len(text) < 3 || text[0] != '-' {
+		return time.Time{}, false
+	}
+	n, err := strconv.Atoi(text[1 : len(text)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	switch text[len(text)-1] {
+	case 's':
+		return now.Add(-time.Duration(n) * time.Second), true
+	case 'm':
+		return now.Add(-time.Duration(n) * time.Minute), true
+	case 'h':
+		return now.Add(-time.Duration(n) * time.Hour), true
+	case 'd':
+		return now.AddDate(0, 0, -n), true
+	case 'w':
+		return now.AddDate(0, 0, -7*n), true
+	}
+	return time.Time{}, false
+}
+
+// 解析快照中的文件大小，文件夹大小为空，返回0
+func ParseSize(size string) int64 {
+	n, _ := strconv.ParseInt(strings.TrimLeft(size, " "), 10, 64)
+	return n
+}
+
 func ParseFileMetadata(text string) FileMetadata {
 	return FileMetadata{Sha1: text[:40], ModTime: text[41:60], Size: text[61:80], Path: text[81:]}
 }
+
+// 匹配路径。以/结尾的模式匹配该文件夹及其下所有文件及文件夹；
+// 否则按通配符匹配完整路径，不含/的模式同时匹配文件名
+func MatchPath(pattern string, path string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(path, pattern)
+	}
+	p := strings.TrimSuffix(path, "/")
+	if ok, _ := filepath.Match(pattern, p); ok {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := filepath.Match(pattern, p[strings.LastIndex(p, "/")+1:])
+		return ok
+	}
+	return false
+}
+
+func MatchPaths(patterns []string, path string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if MatchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
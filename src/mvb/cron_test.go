@@ -0,0 +1,113 @@
+package mvb
+
+import (
+	"testing"
+	"time"
+)
+
+func cronTime(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseCronError(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"@yearly",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) 应返回错误", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		expr string
+		from string
+		want string
+	}{
+		// 不含当前分钟
+		{"* * * * *", "2026-10-19 10:07", "2026-10-19 10:08"},
+		{"*/15 * * * *", "2026-10-19 10:15", "2026-10-19 10:30"},
+		{"*/15 * * * *", "2026-10-19 10:45", "2026-10-19 11:00"},
+		// 步长与范围
+		{"0-30/10 * * * *", "2026-10-19 10:25", "2026-10-19 10:30"},
+		{"0-30/10 * * * *", "2026-10-19 10:30", "2026-10-19 11:00"},
+		{"5/20 * * * *", "2026-10-19 10:26", "2026-10-19 10:45"},
+		{"0 9-17/4 * * *", "2026-10-19 13:00", "2026-10-19 17:00"},
+		{"0 9-17/4 * * *", "2026-10-19 17:00", "2026-10-20 09:00"},
+		{"0,30 1,13 * * *", "2026-10-19 13:30", "2026-10-20 01:00"},
+		// 年、月、日的边界
+		{"59 23 31 12 *", "2026-12-31 23:59", "2027-12-31 23:59"},
+		{"0 0 1 * *", "2026-12-15 00:00", "2027-01-01 00:00"},
+		{"0 0 31 * *", "2026-11-01 00:00", "2026-12-31 00:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		// 日与周都不为*时满足其一即可
+		{"0 0 13 * 5", "2026-10-19 00:00", "2026-10-23 00:00"},
+		{"0 0 13 * 5", "2027-01-10 00:00", "2027-01-13 00:00"},
+		// 只限定日或周时只按其中之一
+		{"0 0 13 * *", "2026-10-19 00:00", "2026-11-13 00:00"},
+		{"0 0 * * 5", "2027-01-10 00:00", "2027-01-15 00:00"},
+		// 周日为0或7
+		{"0 0 * * 0", "2026-10-19 00:00", "2026-10-25 00:00"},
+		{"0 0 * * 7", "2026-10-19 00:00", "2026-10-25 00:00"},
+		{"0 0 * * 6-7", "2026-10-19 00:00", "2026-10-24 00:00"},
+		{"0 0 * * 6-7", "2026-10-24 00:00", "2026-10-25 00:00"},
+		// 宏
+		{"@hourly", "2026-10-19 10:07", "2026-10-19 11:00"},
+		{"@daily", "2026-10-19 10:07", "2026-10-20 00:00"},
+		{"@weekly", "2026-10-19 10:07", "2026-10-25 00:00"},
+		{"@monthly", "2026-10-19 10:07", "2026-11-01 00:00"},
+	}
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(cronTime(tt.from)); !got.Equal(cronTime(tt.want)) {
+			t.Errorf("%q.Next(%s) = %s，应为 %s", tt.expr, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+func TestCronNextSeconds(t *testing.T) {
+	s, err := ParseCron("*/5 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := cronTime("2026-10-19 10:04").Add(59*time.Second + time.Millisecond)
+	if got := s.Next(from); !got.Equal(cronTime("2026-10-19 10:05")) {
+		t.Errorf("Next(%s) = %s，应为 2026-10-19 10:05", from, got)
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	for _, expr := range []string{"0 0 30 2 *", "0 0 31 4 *", "0 0 31 2,4,6,9,11 *"} {
+		s, err := ParseCron(expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", expr, err)
+			continue
+		}
+		if got := s.Next(cronTime("2026-10-19 10:07")); !got.IsZero() {
+			t.Errorf("%q.Next() = %s，应永远不满足", expr, got)
+		}
+	}
+}
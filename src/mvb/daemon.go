@@ -0,0 +1,266 @@
+package mvb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 守护进程状态文件，位于备份存储空间中
+const DAEMON_STATUS_FILE = "daemon.status"
+
+// 守护进程锁文件，同一备份存储空间同时只能运行一个守护进程
+const DAEMON_LOCK_FILE = "daemon.lock"
+
+// 守护进程支持的任务，同一时间到期时按此顺序执行
+var DAEMON_TASKS = []string{"backup", "forget", "gc", "check"}
+
+// 定时任务，Args为执行的mvb命令行参数
+type DaemonTask struct {
+	Name     string
+	Expr     string
+	Schedule *CronSchedule
+	Args     []string
+	Pending  func() bool // 不为nil时，返回false表示没有需要执行的内容，跳过本次执行
+}
+
+type TaskStatus struct {
+	Type      string     `json:"type"` // daemon_task
+	Name      string     `json:"name"`
+	Schedule  string     `json:"schedule"`
+	Running   bool       `json:"running"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastEnd   *time.Time `json:"last_end,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+}
+
+type DaemonStatus struct {
+	Type    string       `json:"type"` // daemon
+	Pid     int          `json:"pid"`
+	Started time.Time    `json:"started"`
+	Stopped *time.Time   `json:"stopped,omitempty"`
+	Tasks   []TaskStatus `json:"tasks"`
+}
+
+// 从配置文件的[schedule]中读取定时任务，forget需在[forget]中以versions指定要删除的版本范围，如..@-90d，
+// 以keep指定始终保留的最新版本数，默认为1，避免范围包含所有版本时删除所有版本
+func LoadDaemonTasks() ([]DaemonTask, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	for name := range config["schedule"] {
+		if !isDaemonTask(name) {
+			return nil, fmt.Errorf("不支持的任务：%s，支持：%s", name, strings.Join(DAEMON_TASKS, "、"))
+		}
+	}
+
+	var tasks []DaemonTask
+	for _, name := range DAEMON_TASKS {
+		expr := config.Get("schedule", name)
+		if expr == "" {
+			continue
+		}
+		schedule, err := ParseCron(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s：%v", name, err)
+		}
+		if schedule.Next(time.Now()).IsZero() {
+			return nil, fmt.Errorf("%s：计划永远不会执行：%s", name, expr)
+		}
+		task := DaemonTask{Name: name, Expr: expr, Schedule: schedule, Args: []string{name}}
+		if name == "forget" {
+			versions := config.Get("forget", "versions")
+			if !strings.Contains(versions, "..") {
+				return nil, fmt.Errorf("forget：需在配置文件[forget]中以versions指定版本范围")
+			}
+			keep := 1
+			if s := config.Get("forget", "keep"); s != "" {
+				if keep, err = strconv.Atoi(s); err != nil || keep < 0 {
+					return nil, fmt.Errorf("forget：keep需为非负整数：%s", s)
+				}
+			}
+			task.Args = []string{"delete", versions, "--keep", strconv.Itoa(keep)}
+			task.Pending = func() bool { return hasForgettableVersions(versions, keep) }
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// 版本范围中是否有除最新keep个版本之外的版本。范围错误时返回true，由delete报告错误
+func hasForgettableVersions(versions string, keep int) bool {
+	indexes, err := ResolveVersionRange(versions)
+	if err != nil {
+		return true
+	}
+	n := GetIndexVersionCount() - keep
+	for _, i := range indexes {
+		if i < n {
+			return true
+		}
+	}
+	return false
+}
+
+func isDaemonTask(name string) bool {
+	for _, t := range DAEMON_TASKS {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 按计划依次执行任务，同一时间只执行一个任务。每个任务在独立的进程组中执行mvb，
+// 中断信号不会传递给任务，任务中途不会被中断，写入索引等操作总能完成
+type Daemon struct {
+	OnTask func(TaskStatus) // 每个任务执行完成后调用
+
+	tasks  []DaemonTask
+	status DaemonStatus
+}
+
+func NewDaemon(tasks []DaemonTask) *Daemon {
+	return &Daemon{tasks: tasks}
+}
+
+// 开始执行计划任务，stop关闭后等待正在执行的任务完成后返回。
+// 同一备份存储空间中已有守护进程运行时返回错误，避免重复执行任务及互相覆盖状态文件
+func (d *Daemon) Run(stop <-chan struct{}) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	lock, err := lockFile(DAEMON_LOCK_FILE)
+	if err == errLocked {
+		return fmt.Errorf("守护进程已在运行：%s%v", DAEMON_LOCK_FILE, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	now := time.Now()
+	d.status = DaemonStatus{Type: "daemon", Pid: os.Getpid(), Started: now}
+	for _, t := range d.tasks {
+		s := TaskStatus{Type: "daemon_task", Name: t.Name, Schedule: t.Expr}
+		s.NextRun = nextRun(t.Schedule, now)
+		d.status.Tasks = append(d.status.Tasks, s)
+	}
+	d.writeStatus()
+
+	for {
+		// 最多等待1分钟，避免系统休眠或调整时钟后错过计划
+		wait := time.Minute
+		for _, s := range d.status.Tasks {
+			if s.NextRun != nil && time.Until(*s.NextRun) < wait {
+				wait = time.Until(*s.NextRun)
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			stopped := time.Now()
+			d.status.Stopped = &stopped
+			d.writeStatus()
+			return nil
+		case <-timer.C:
+		}
+
+		for i := range d.tasks {
+			select {
+			case <-stop:
+				continue
+			default:
+			}
+			s := &d.status.Tasks[i]
+			if s.NextRun == nil || time.Now().Before(*s.NextRun) {
+				continue
+			}
+			d.runTask(exe, i)
+			// 执行期间错过的计划不再补执行
+			s.NextRun = nextRun(d.tasks[i].Schedule, time.Now())
+			d.writeStatus()
+			if d.OnTask != nil {
+				d.OnTask(*s)
+			}
+		}
+	}
+}
+
+func nextRun(schedule *CronSchedule, t time.Time) *time.Time {
+	next := schedule.Next(t)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+func (d *Daemon) runTask(exe string, i int) {
+	task := d.tasks[i]
+	s := &d.status.Tasks[i]
+	if task.Pending != nil && !task.Pending() {
+		Verbosef("跳过任务：%s，没有需要执行的内容\n", task.Name)
+		return
+	}
+	start := time.Now()
+	s.Running, s.LastRun = true, &start
+	d.writeStatus()
+
+	var output bytes.Buffer
+	cmd := exec.Command(exe, task.Args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	detachProcess(cmd)
+	Verbosef("执行任务：%s %s\n", task.Name, strings.Join(task.Args, " "))
+	err := cmd.Run()
+	Verbosef("%s", output.String())
+
+	end := time.Now()
+	s.Running, s.LastEnd, s.LastError = false, &end, ""
+	if err != nil {
+		s.LastError = err.Error()
+		if lines := strings.Split(strings.TrimSpace(output.String()), "\n"); lines[len(lines)-1] != "" {
+			s.LastError += "：" + lines[len(lines)-1]
+		}
+	}
+}
+
+// 先写入临时文件再替换，读取状态时不会读到写了一半的文件
+func (d *Daemon) writeStatus() {
+	data, err := json.MarshalIndent(d.status, "", "  ")
+	if err != nil {
+		Errorf("writeStatus: %v", err)
+	}
+	tmp := DAEMON_STATUS_FILE + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		Errorf("writeStatus: %v", err)
+	}
+	if err := os.Rename(tmp, DAEMON_STATUS_FILE); err != nil {
+		Errorf("writeStatus: %v", err)
+	}
+}
+
+func ReadDaemonStatus() (DaemonStatus, error) {
+	var status DaemonStatus
+	data, err := ioutil.ReadFile(DAEMON_STATUS_FILE)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return status, fmt.Errorf("守护进程未运行过：%s不存在", DAEMON_STATUS_FILE)
+		}
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return status, fmt.Errorf("状态文件格式错误：%s：%v", DAEMON_STATUS_FILE, err)
+	}
+	return status, nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package mvb
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// 任务在独立的进程组中执行，终端的中断信号不会传递给任务
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,11 @@
+package mvb
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// 任务在独立的进程组中执行，控制台的Ctrl+C不会传递给任务
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
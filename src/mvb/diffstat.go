@@ -0,0 +1,65 @@
+package mvb
+
+import (
+	"sort"
+	"strings"
+)
+
+type DiffStat struct {
+	Dir      string // 顶层文件夹，根目录下的文件为空
+	Added    int
+	Removed  int
+	Modified int
+	Renamed  int
+	Metadata int   // 只有元数据变化的文件
+	Bytes    int64 // 文件大小变化
+}
+
+// 按顶层文件夹统计差异，只统计文件，from为比较前的文件列表，用于获取修改前的文件大小
+func GetDiffStats(diffs []DiffFileMetadata, from []FileMetadata) []DiffStat {
+	stats := map[string]*DiffStat{}
+	for _, d := range diffs {
+		if strings.HasSuffix(d.Path, "/") {
+			continue
+		}
+		dir := ""
+		if i := strings.Index(d.Path, "/"); i >= 0 {
+			dir = d.Path[:i+1]
+		}
+		s, ok := stats[dir]
+		if !ok {
+			s = &DiffStat{Dir: dir}
+			stats[dir] = s
+		}
+
+		switch d.Type {
+		case "+":
+			s.Added++
+			s.Bytes += ParseSize(d.Size)
+		case "-":
+			s.Removed++
+			s.Bytes -= ParseSize(d.Size)
+		case "*":
+			s.Modified++
+			s.Bytes += ParseSize(d.Size)
+			if f := SearchFile(from, d.Path); f != nil {
+				s.Bytes -= ParseSize(f.Size)
+			}
+		case "R":
+			s.Renamed++
+			s.Bytes += ParseSize(d.Size)
+			if f := SearchFile(from, d.OldPath); f != nil {
+				s.Bytes -= ParseSize(f.Size)
+			}
+		case "M":
+			s.Metadata++
+		}
+	}
+
+	var r []DiffStat
+	for _, s := range stats {
+		r = append(r, *s)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Dir < r[j].Dir })
+	return r
+}
@@ -0,0 +1,136 @@
+package mvb
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// 快照中未记录文件权限，导出时文件夹使用0755，文件使用0644
+const EXPORT_DIR_MODE = 0755
+const EXPORT_FILE_MODE = 0644
+
+// 根据文件名推断归档格式，无法推断时返回空
+func GuessArchiveType(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// 将快照中以prefix开头的文件及文件夹导出为归档，直接从objects读取文件内容
+func ExportFiles(w io.Writer, files []FileMetadata, prefix string, archiveType string) {
+	if err := WriteArchive(w, files, prefix, archiveType); err != nil {
+		Errorf("ExportFiles: %v", err)
+	}
+}
+
+// 与ExportFiles相同，但出错时返回错误而不退出
+func WriteArchive(w io.Writer, files []FileMetadata, prefix string, archiveType string) error {
+	switch archiveType {
+	case "tar":
+		return exportTar(w, files, prefix)
+	case "tar.gz":
+		gw := gzip.NewWriter(w)
+		if err := exportTar(gw, files, prefix); err != nil {
+			return err
+		}
+		return gw.Close()
+	case "zip":
+		return exportZip(w, files, prefix)
+	}
+	return fmt.Errorf("不支持的归档格式：%s", archiveType)
+}
+
+// 将多个版本导出到同一归档，每个版本位于以时间戳命名的文件夹中，同一时间有多个版本时以SHA1命名
+func ExportVersions(w io.Writer, versions []Version, prefix string, archiveType string) {
+	var files []FileMetadata
+	for i, name := range NameVersions(versions) {
+		files = append(files, FileMetadata{Sha1: EMPTY_SHA1, ModTime: versions[i].Timestamp, Size: EMPTY_SIZE, Path: name + "/"})
+		for _, f := range GetVersionFiles(versions[i].Sha1) {
+			if strings.HasPrefix(f.Path, prefix) {
+				f.Path = name + "/" + f.Path
+				files = append(files, f)
+			}
+		}
+	}
+	ExportFiles(w, files, "", archiveType)
+}
+
+func exportTar(w io.Writer, files []FileMetadata, prefix string) error {
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		if !strings.HasPrefix(f.Path, prefix) {
+			continue
+		}
+		t, _ := time.Parse(ISO8601, f.ModTime)
+		h := &tar.Header{Name: f.Path, ModTime: t, Format: tar.FormatPAX}
+		if strings.HasSuffix(f.Path, "/") {
+			h.Typeflag = tar.TypeDir
+			h.Mode = EXPORT_DIR_MODE
+		} else {
+			h.Typeflag = tar.TypeReg
+			h.Mode = EXPORT_FILE_MODE
+			h.Size = ParseSize(f.Size)
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeReg {
+			if err := copyObject(f.Sha1, tw); err != nil {
+				return err
+			}
+		}
+		Verbosef("导出：%s\n", f.Path)
+	}
+	return tw.Close()
+}
+
+func exportZip(w io.Writer, files []FileMetadata, prefix string) error {
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		if !strings.HasPrefix(f.Path, prefix) {
+			continue
+		}
+		t, _ := time.Parse(ISO8601, f.ModTime)
+		h := &zip.FileHeader{Name: f.Path, Modified: t}
+		if strings.HasSuffix(f.Path, "/") {
+			h.SetMode(os.ModeDir | EXPORT_DIR_MODE)
+		} else {
+			h.Method = zip.Deflate
+			h.SetMode(EXPORT_FILE_MODE)
+		}
+		fw, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(f.Path, "/") {
+			if err := copyObject(f.Sha1, fw); err != nil {
+				return err
+			}
+		}
+		Verbosef("导出：%s\n", f.Path)
+	}
+	return zw.Close()
+}
+
+// 将对象内容写入w，对象不存在或内容与SHA1不一致时返回错误
+func copyObject(objectSha1 string, w io.Writer) error {
+	r, err := OpenObject(objectSha1)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
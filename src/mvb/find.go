@@ -0,0 +1,151 @@
+package mvb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FileFilter struct {
+	Patterns []string  // 路径模式，参见MatchPath，为空匹配所有路径
+	Newer    time.Time // 最后修改时间晚于此时间，为零值时不限制
+	Older    time.Time // 最后修改时间早于此时间，为零值时不限制
+	MinSize  int64     // 最小文件大小，为0时不限制
+	MaxSize  int64     // 最大文件大小，为math.MaxInt64时不限制，小于0时（如-0）不匹配任何文件
+	Sha1     string    // 文件SHA1前缀
+}
+
+type VersionFiles struct {
+	Version
+	Files []FileMetadata
+}
+
+func NewFileFilter() *FileFilter {
+	return &FileFilter{MinSize: 0, MaxSize: math.MaxInt64}
+}
+
+// 解析文件大小条件，与find命令相同：+N 大于N，-N 小于N，N 等于N，支持K、M、G单位
+func (filter *FileFilter) ParseSize(size string) error {
+	s := size
+	sign := byte(0)
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		sign = s[0]
+		s = s[1:]
+	}
+	unit := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			unit = 1 << 10
+		case 'm', 'M':
+			unit = 1 << 20
+		case 'g', 'G':
+			unit = 1 << 30
+		}
+		if unit > 1 {
+			s = s[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("文件大小格式错误：%s", size)
+	}
+	n *= unit
+	switch sign {
+	case '+':
+		filter.MinSize = n + 1
+	case '-':
+		filter.MaxSize = n - 1
+	default:
+		filter.MinSize = n
+		filter.MaxSize = n
+	}
+	return nil
+}
+
+func (filter *FileFilter) matchPath(path string) bool {
+	return MatchPaths(filter.Patterns, path)
+}
+
+func (filter *FileFilter) Match(file FileMetadata) bool {
+	if !filter.matchPath(file.Path) {
+		return false
+	}
+	dir := strings.HasSuffix(file.Path, "/")
+	if filter.Sha1 != "" && (dir || !strings.HasPrefix(file.Sha1, filter.Sha1)) {
+		return false
+	}
+	if filter.MinSize > 0 || filter.MaxSize < math.MaxInt64 {
+		size := ParseSize(file.Size)
+		if dir || size < filter.MinSize || size > filter.MaxSize {
+			return false
+		}
+	}
+	if !filter.Newer.IsZero() || !filter.Older.IsZero() {
+		t, _ := time.Parse(ISO8601, file.ModTime)
+		if !filter.Newer.IsZero() && !t.After(filter.Newer) || !filter.Older.IsZero() && !t.Before(filter.Older) {
+			return false
+		}
+	}
+	return true
+}
+
+// 在版本快照中查找匹配的文件。快照逐行扫描，先匹配路径再解析，避免解析整个快照
+func FindVersionFiles(version Version, filter *FileFilter) []FileMetadata {
+	data, err := ioutil.ReadFile(GetObjectPath(version.Sha1))
+	if err != nil {
+		Errorf("FindVersionFiles: %v", err)
+	}
+
+	var files []FileMetadata
+	o := string(data)
+	for len(o) > 0 {
+		i := strings.IndexByte(o, '\n')
+		if i < 0 {
+			i = len(o)
+		}
+		line := o[:i]
+		if i < len(o) {
+			i++
+		}
+		o = o[i:]
+
+		if len(line) > 81 && filter.matchPath(line[81:]) {
+			if f := ParseFileMetadata(line); filter.Match(f) {
+				files = append(files, f)
+			}
+		}
+	}
+	return files
+}
+
+// 并发查找多个版本，结果顺序与versions相同，不包含没有匹配文件的版本
+func FindFiles(versions []Version, filter *FileFilter) []VersionFiles {
+	results := make([]VersionFiles, len(versions))
+
+	var wg sync.WaitGroup
+	sem := make(chan int, MAX_GOS)
+	for i := range versions {
+		sem <- 1
+		wg.Add(1)
+		go func(i int) {
+			results[i] = VersionFiles{Version: versions[i], Files: FindVersionFiles(versions[i], filter)}
+			wg.Done()
+			<-sem
+		}(i)
+	}
+	wg.Wait()
+	close(sem)
+
+	var r []VersionFiles
+	for _, v := range results {
+		if len(v.Files) > 0 {
+			r = append(r, v)
+		}
+	}
+	return r
+}
@@ -0,0 +1,102 @@
+package mvb
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type LineMatch struct {
+	Line int // 行号，从1开始
+	Text string
+}
+
+type GrepMatch struct {
+	Version
+	File  FileMetadata
+	Lines []LineMatch
+}
+
+// 在对象内容中查找匹配的行，二进制对象返回nil
+func GrepObject(objectSha1 string, re *regexp.Regexp) []LineMatch {
+	f, err := os.Open(GetObjectPath(objectSha1))
+	if err != nil {
+		Errorf("GrepObject: %v", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	if head, _ := r.Peek(8000); IsBinary(head) {
+		return nil
+	}
+
+	var matches []LineMatch
+	for n := 1; ; n++ {
+		line, err := r.ReadString('\n')
+		if len(line) > 0 {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			if re.MatchString(line) {
+				matches = append(matches, LineMatch{Line: n, Text: line})
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				Errorf("GrepObject: %v", err)
+			}
+			break
+		}
+	}
+	return matches
+}
+
+// 在多个版本中查找内容匹配的文件，prefix为路径前缀。
+// 内容相同的对象只读取一次，并发读取对象，结果按版本、路径排序
+func Grep(versions []Version, prefix string, re *regexp.Regexp) []GrepMatch {
+	var files []GrepMatch
+	var shas []string
+	seen := map[string]bool{}
+	for _, v := range versions {
+		for _, f := range GetVersionFiles(v.Sha1) {
+			if strings.HasSuffix(f.Path, "/") || !strings.HasPrefix(f.Path, prefix) {
+				continue
+			}
+			files = append(files, GrepMatch{Version: v, File: f})
+			if !seen[f.Sha1] {
+				seen[f.Sha1] = true
+				shas = append(shas, f.Sha1)
+			}
+		}
+	}
+
+	objects := map[string][]LineMatch{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan int, MAX_GOS)
+	for _, s := range shas {
+		sem <- 1
+		wg.Add(1)
+		go func(s string) {
+			Verbosef("查找：%s\n", s)
+			matches := GrepObject(s, re)
+			mu.Lock()
+			objects[s] = matches
+			mu.Unlock()
+			wg.Done()
+			<-sem
+		}(s)
+	}
+	wg.Wait()
+	close(sem)
+
+	var r []GrepMatch
+	for _, f := range files {
+		if lines := objects[f.File.Sha1]; len(lines) > 0 {
+			f.Lines = lines
+			r = append(r, f)
+		}
+	}
+	return r
+}
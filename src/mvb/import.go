@@ -0,0 +1,237 @@
+package mvb
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 将数据流保存为对象，边写入临时文件边计算SHA1，对象已存在时丢弃临时文件。返回对象SHA1及大小
+func ImportObject(r io.Reader) (string, int64) {
+	if err := os.MkdirAll("objects", os.ModeDir|0774); err != nil {
+		Errorf("ImportObject: %v", err)
+	}
+	w, err := ioutil.TempFile("objects", OBJECT_TEMP_IMPORT)
+	if err != nil {
+		Errorf("ImportObject: %v", err)
+	}
+	tmp := w.Name()
+
+	h := sha1.New()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err == nil {
+		err = w.Close()
+	} else {
+		w.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		Errorf("ImportObject: %v", err)
+	}
+
+	s := hex.EncodeToString(h.Sum(nil))
+	if IsObjectExist(s) {
+		Verbosef("文件已存在： %s\n", s)
+		os.Remove(tmp)
+		return s, n
+	}
+	dst := GetObjectPath(s)
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0774); err != nil {
+		Errorf("ImportObject: %v", err)
+	}
+	if err := os.Chmod(tmp, OBJECT_MODE); err != nil {
+		Errorf("ImportObject: %v", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		Errorf("ImportObject: %v", err)
+	}
+	return s, n
+}
+
+// 保存版本快照并加入索引，快照已存在时不重复加入，返回快照SHA1。文件对象需已保存
+func SaveVersion(files []FileMetadata, timestamp time.Time) string {
+	version := StringifyVersionObject(files)
+	versionSha1 := Sha1([]byte(version))
+
+	if !IsObjectExist(versionSha1) {
+		WriteVersionObject(versionSha1, version)
+		AddVersionToIndex(Version{Sha1: versionSha1, Timestamp: timestamp.Format(ISO8601)})
+	} else {
+		Verbosef("版本已存在： %s\n", versionSha1)
+	}
+	return versionSha1
+}
+
+// 归档中的路径，去掉开头的./，与快照中的路径相同，不允许绝对路径及..。
+// 快照每行记录一个路径，也不允许换行及回车
+func cleanArchivePath(name string) (string, error) {
+	p := strings.TrimPrefix(strings.Replace(name, "\\", "/", -1), "./")
+	if p == "" || p == "." {
+		return "", nil
+	}
+	if strings.ContainsAny(p, "\r\n") {
+		return "", fmt.Errorf("归档路径包含换行：%q", name)
+	}
+	c := path.Clean(p)
+	if err := CheckSnapshotPath(c); err != nil {
+		return "", fmt.Errorf("归档%v", err)
+	}
+	return c, nil
+}
+
+type archiveFiles map[string]FileMetadata
+
+func (files archiveFiles) addDir(p string, modTime time.Time) {
+	files[p+"/"] = FileMetadata{Path: p + "/", ModTime: modTime.Format(ISO8601), Size: EMPTY_SIZE, Sha1: EMPTY_SHA1}
+}
+
+func (files archiveFiles) addFile(p string, modTime time.Time, r io.Reader) {
+	s, n := ImportObject(r)
+	files[p] = FileMetadata{Path: p, ModTime: modTime.Format(ISO8601), Size: fmt.Sprintf("%19d", n), Sha1: s}
+	Verbosef("导入：%s\n", p)
+}
+
+// 补充归档中未单独记录的上级文件夹，最后修改时间取其下文件及文件夹中最晚的时间，并按路径排序
+func (files archiveFiles) sorted() []FileMetadata {
+	dirs := map[string]string{}
+	for p, f := range files {
+		for d := path.Dir(strings.TrimSuffix(p, "/")); d != "."; d = path.Dir(d) {
+			if _, ok := files[d+"/"]; !ok && dirs[d] < f.ModTime {
+				dirs[d] = f.ModTime
+			}
+		}
+	}
+	for d, t := range dirs {
+		files[d+"/"] = FileMetadata{Path: d + "/", ModTime: t, Size: EMPTY_SIZE, Sha1: EMPTY_SHA1}
+	}
+
+	var r FileMetadataSlice
+	for _, f := range files {
+		r = append(r, f)
+	}
+	sort.Sort(r)
+	return r
+}
+
+// 读取tar、tar.gz或zip归档中的文件及文件夹，文件内容保存为对象，返回快照文件列表。
+// 归档格式根据文件头判断，name为空时从标准输入读取，zip需要随机读取，不支持从标准输入读取。符号链接等其他类型的条目被忽略
+func ImportArchive(name string) []FileMetadata {
+	var f *os.File
+	if name == "" {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(name); err != nil {
+			Errorf("ImportArchive: %v", err)
+		}
+		defer f.Close()
+	}
+
+	r := bufio.NewReader(f)
+	head, _ := r.Peek(4)
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")) {
+		if f == os.Stdin {
+			Errorf("不支持从标准输入导入zip归档")
+		}
+		return importZip(f)
+	}
+	if bytes.HasPrefix(head, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			Errorf("ImportArchive: %v", err)
+		}
+		defer gr.Close()
+		return importTar(gr)
+	}
+	return importTar(r)
+}
+
+func importTar(r io.Reader) []FileMetadata {
+	files := archiveFiles{}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			Errorf("ImportArchive: %v", err)
+		}
+		p, err := cleanArchivePath(h.Name)
+		if err != nil {
+			Errorf("%v", err)
+		}
+		if p == "" {
+			continue
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			files.addDir(p, h.ModTime)
+		case tar.TypeReg, tar.TypeRegA:
+			files.addFile(p, h.ModTime, tr)
+		default:
+			Verbosef("忽略：%s\n", h.Name)
+		}
+	}
+	return files.sorted()
+}
+
+func importZip(f *os.File) []FileMetadata {
+	fi, err := f.Stat()
+	if err != nil {
+		Errorf("ImportArchive: %v", err)
+	}
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		Errorf("ImportArchive: %v", err)
+	}
+
+	files := archiveFiles{}
+	for _, zf := range zr.File {
+		p, err := cleanArchivePath(zf.Name)
+		if err != nil {
+			Errorf("%v", err)
+		}
+		if p == "" {
+			continue
+		}
+		mode := zf.Mode()
+		if mode.IsDir() {
+			files.addDir(p, zf.Modified)
+		} else if mode.IsRegular() {
+			r, err := zf.Open()
+			if err != nil {
+				Errorf("ImportArchive: %v", err)
+			}
+			files.addFile(p, zf.Modified, r)
+			r.Close()
+		} else {
+			Verbosef("忽略：%s\n", zf.Name)
+		}
+	}
+	return files.sorted()
+}
+
+// 将数据流保存为只包含一个文件的快照文件列表，name为文件路径
+func ImportStream(r io.Reader, name string, timestamp time.Time) []FileMetadata {
+	p, err := cleanArchivePath(name)
+	if err != nil || p == "" {
+		Errorf("文件名不合法：%s", name)
+	}
+	files := archiveFiles{}
+	files.addFile(p, timestamp, r)
+	return files.sorted()
+}
@@ -1,11 +1,13 @@
 package mvb
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strings"
 	"strconv"
+	"time"
 )
 
 type ReverseIndex struct {
@@ -48,18 +50,6 @@ func (ri *ReverseIndex) NextVersion() string {
 	return ""
 }
 
-func ParseIndexedVersion(a string) int {
-	i, err := strconv.Atoi(a[1:])
-	if err != nil {
-		Errorf("ParseIndexedVersion：%s", a)
-	}
-	if i > 0 {
-		return i - 1
-	} else {
-		return GetIndexVersionCount() + i
-	}
-}
-
 func WriteReverseIndexTo(w *os.File)  {
 	i, err := NewReverseIndex()
 	if err != nil {
@@ -87,70 +77,6 @@ func AddVersionToIndex(version Version) {
 	f.WriteString(StringifyVersion(version))
 }
 
-func DeleteIndexVersionAt(i int)  {
-	f, err := os.OpenFile("index", os.O_RDWR, 0644)
-	if err != nil {
-		Errorf("DeleteIndexVersionAt: %v", err)
-	}
-	defer f.Close()
-
-	w := int64(i)*int64(VERSION_LEN)
-	if _, err := f.Seek(w + int64(VERSION_LEN), os.SEEK_SET); err!= nil {
-		Errorf("DeleteIndexVersionAt: %v", err)
-	}
-
-	buffer := make([]byte, 4 * 1024)
-	for {
-		n, err := f.Read(buffer)
-		if err != nil {
-			if err == io.EOF {
-				if err := f.Truncate(w); err != nil {
-					Errorf("DeleteIndexVersionAt: %v", err)
-				}
-				break
-			}
-		}
-		f.WriteAt(buffer[:n], w)
-		w += int64(n)
-	}
-}
-
-func DeleteIndexVersion(pattern string)  {
-	f, err := os.OpenFile("index", os.O_RDWR, 0644)
-	if err != nil {
-		Errorf("DeleteIndexVersion: %v", err)
-	}
-	defer f.Close()
-
-	r := int64(0)
-	w := int64(0)
-
-	buffer := make([]byte, VERSION_LEN)
-	for {
-		if _, err = f.ReadAt(buffer, r); err != nil {
-			if err == io.EOF {
-				if err = f.Truncate(w); err != nil {
-					Errorf("DeleteIndexVersion: %v", err)
-				}
-				break
-			} else {
-				Errorf("DeleteIndexVersion: %v", err)
-			}
-		}
-
-		r += int64(VERSION_LEN)
-		v := string(buffer)
-		if !MatchVersion(pattern, ParseVersion(v[:len(v) -1])) {
-			if r != w {
-				if _, err := f.WriteAt(buffer, w); err != nil {
-					Errorf("DeleteIndexVersion: %v", err)
-				}
-			}
-			w += int64(VERSION_LEN)
-		}
-	}
-}
-
 func GetIndexVersionCount() int {
 	fi, err := os.Stat("index")
 	if err != nil {
@@ -198,54 +124,297 @@ func GetLatestVersionSha1() string {
 	return ParseVersion(GetIndexVersionAt(n - 1)).Sha1
 }
 
+// 查找SHA1为versionSha1的版本，返回版本及其在索引中的位置（从1开始），不存在时位置为0
+func FindIndexVersion(versionSha1 string) (Version, int) {
+	versions := GetIndexVersions()
+	for i := len(versions) - 1; i >= 0; i-- {
+		if v := ParseVersion(versions[i]); v.Sha1 == versionSha1 {
+			return v, i + 1
+		}
+	}
+	return Version{Sha1: versionSha1}, 0
+}
+
 func MatchVersion(pattern string, version Version) bool {
 	return strings.HasPrefix(version.Sha1, pattern) || strings.HasPrefix(version.Timestamp, pattern)
 }
 
-func FindIndexVersions(pattern string) (r []string) {
-	f, err := os.Open("index")
+func ResolveVersions(pattern string) []string {
+	versions := GetIndexVersions()
+	var r []string
+	for _, i := range ResolveVersionIndexes(pattern) {
+		r = append(r, versions[i])
+	}
+	return r
+}
+
+// 解析版本号、版本范围或版本前缀，返回所有匹配的版本在索引中的位置。
+// 版本范围及v1、@2006-01-02、v-1~2等只能对应唯一版本的格式无匹配时退出，前缀无匹配时返回空
+func ResolveVersionIndexes(pattern string) []int {
+	if strings.Contains(pattern, "..") {
+		r, err := ResolveVersionRange(pattern)
+		if err != nil {
+			Errorf("%v", err)
+		}
+		if len(r) == 0 {
+			Errorf("未找到对应的版本：%s", pattern)
+		}
+		return r
+	}
+	if strings.HasPrefix(pattern, "v") || strings.HasPrefix(pattern, "@") || strings.ContainsAny(pattern, "~^") {
+		i, err := LookupVersionIndex(pattern)
+		if err != nil {
+			Errorf("%v", err)
+		}
+		return []int{i}
+	}
+	var r []int
+	for i, v := range GetIndexVersions() {
+		if MatchVersion(pattern, ParseVersion(v)) {
+			r = append(r, i)
+		}
+	}
+	return r
+}
+
+func ResolveVersionSha1(pattern string) string {
+	version, err := LookupVersion(pattern)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return r
-		} else {
-			Errorf("FindIndexVersion: %v", err)
+		Errorf("%v", err)
+	}
+	return version.Sha1
+}
+
+// 查找唯一的版本，与ResolveVersionSha1相同，但版本不存在或不唯一时返回错误而不退出
+func LookupVersion(pattern string) (Version, error) {
+	i, err := LookupVersionIndex(pattern)
+	if err != nil {
+		return Version{}, err
+	}
+	return ParseVersion(GetIndexVersionAt(i)), nil
+}
+
+// 查找唯一的版本，返回其在索引中的位置。版本号后可跟相对后缀，~N为N个版本之前，^为1个版本之前，
+// 可连续使用，如v-1~2、da39^^
+func LookupVersionIndex(pattern string) (int, error) {
+	base, offset, err := splitRelativeVersion(pattern)
+	if err != nil {
+		return -1, err
+	}
+
+	var r []int
+	if strings.HasPrefix(base, "v") {
+		i, err := strconv.Atoi(base[1:])
+		if err != nil {
+			return -1, fmt.Errorf("版本号格式错误：%s", pattern)
+		}
+		if n := GetIndexVersionCount(); i > 0 && i <= n {
+			r = []int{i - 1}
+		} else if i <= 0 && n+i >= 0 && n+i < n {
+			r = []int{n + i}
+		}
+	} else if strings.HasPrefix(base, "@") {
+		t, err := ParseTime(base[1:])
+		if err != nil {
+			return -1, err
+		}
+		if i := FindIndexVersionAt(t); i >= 0 {
+			r = []int{i}
+		}
+	} else {
+		for i, v := range GetIndexVersions() {
+			if MatchVersion(base, ParseVersion(v)) {
+				r = append(r, i)
+			}
 		}
 	}
-	defer f.Close()
+	if len(r) == 0 || r[0]-offset < 0 {
+		return -1, fmt.Errorf("未找到对应的版本：%s", pattern)
+	}
+	if len(r) > 1 {
+		return -1, fmt.Errorf("找到多个版本，请输入更精确的版本号：%s", pattern)
+	}
+	return r[0] - offset, nil
+}
 
-	buffer := make([]byte, VERSION_LEN)
+// 拆分版本号及相对后缀，返回版本号及向前的版本数
+func splitRelativeVersion(pattern string) (string, int, error) {
+	base := pattern
+	offset := 0
 	for {
-		_, err := io.ReadFull(f, buffer)
-		if err != nil {
-			if err == io.EOF {
-				return r
-			} else {
-				Errorf("FindIndexVersion: %v", err)
+		if strings.HasSuffix(base, "^") {
+			base = base[:len(base)-1]
+			offset++
+			continue
+		}
+		i := strings.LastIndex(base, "~")
+		if i < 0 {
+			break
+		}
+		n := 1
+		if i < len(base)-1 {
+			var err error
+			if n, err = strconv.Atoi(base[i+1:]); err != nil || n < 0 {
+				return "", 0, fmt.Errorf("版本号格式错误：%s", pattern)
 			}
 		}
+		base = base[:i]
+		offset += n
+	}
+	if base == "" {
+		return "", 0, fmt.Errorf("版本号格式错误：%s", pattern)
+	}
+	return base, offset, nil
+}
 
-		v := string(buffer[:VERSION_LEN- 1])
-		if MatchVersion(pattern, ParseVersion(v)) {
-			r = append(r, v)
+// 版本范围的一端，按索引位置或按时间戳限定
+type versionBound struct {
+	index  int
+	time   time.Time
+	byTime bool
+}
+
+// 解析版本范围a..b，包含两端，任一端为空时不限。两端可以是版本号，按索引位置限定；
+// 也可以是日期2006-01、2006-01-02、2006-01-02T15:04、2006-01-02T15:04:05，包含整个时间段，
+// 或@日期表达式，按时间戳限定
+func ResolveVersionRange(pattern string) ([]int, error) {
+	parts := strings.SplitN(pattern, "..", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("版本范围格式错误：%s", pattern)
+	}
+	versions := GetIndexVersions()
+	from, err := parseVersionBound(parts[0], false, len(versions))
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseVersionBound(parts[1], true, len(versions))
+	if err != nil {
+		return nil, err
+	}
+
+	r := []int{}
+	for i, v := range versions {
+		t, err := time.Parse(ISO8601, ParseVersion(v).Timestamp)
+		if err != nil && (from.byTime || to.byTime) {
+			continue
+		}
+		if from.byTime && t.Before(from.time) || !from.byTime && i < from.index {
+			continue
+		}
+		if to.byTime && t.After(to.time) || !to.byTime && i > to.index {
+			continue
 		}
+		r = append(r, i)
 	}
+	return r, nil
 }
 
-func ResolveVersions(pattern string) []string {
-	if strings.HasPrefix(pattern, "v") {
-		return []string{GetIndexVersionAt(ParseIndexedVersion(pattern))}
-	} else {
-		return FindIndexVersions(pattern)
+func parseVersionBound(text string, end bool, n int) (versionBound, error) {
+	if text == "" {
+		if end {
+			return versionBound{index: n - 1}, nil
+		}
+		return versionBound{index: 0}, nil
 	}
+	if strings.HasPrefix(text, "@") {
+		t, err := ParseTime(text[1:])
+		return versionBound{time: t, byTime: true}, err
+	}
+	if start, next, ok := parsePeriod(text); ok {
+		if end {
+			return versionBound{time: next.Add(-time.Nanosecond), byTime: true}, nil
+		}
+		return versionBound{time: start, byTime: true}, nil
+	}
+	i, err := LookupVersionIndex(text)
+	return versionBound{index: i}, err
 }
 
-func ResolveVersionSha1(pattern string) string {
-	versions := ResolveVersions(pattern)
-	if len(versions) == 0 {
-		Errorf("未找到对应的版本：%s", pattern)
+// 解析本地时间表示的时间段，返回其开始时间及下一时间段的开始时间，如2006-01为整个1月
+func parsePeriod(text string) (time.Time, time.Time, bool) {
+	periods := []struct {
+		layout string
+		next   func(time.Time) time.Time
+	}{
+		{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+		{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+		{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+		{"2006-01-02T15:04:05", func(t time.Time) time.Time { return t.Add(time.Second) }},
 	}
-	if len(versions) > 1 {
-		Errorf("找到多个版本，请输入更精确的版本号：%s", pattern)
+	for _, p := range periods {
+		if t, err := time.ParseInLocation(p.layout, text, time.Local); err == nil {
+			return t, p.next(t), true
+		}
 	}
-	return ParseVersion(versions[0]).Sha1
-}
\ No newline at end of file
+	return time.Time{}, time.Time{}, false
+}
+
+// 为版本命名，以时间戳命名，同一时间有多个版本时以SHA1命名
+func NameVersions(versions []Version) []string {
+	count := map[string]int{}
+	for _, v := range versions {
+		count[v.Timestamp]++
+	}
+	names := make([]string, len(versions))
+	for i, v := range versions {
+		names[i] = v.Timestamp
+		if count[v.Timestamp] > 1 {
+			names[i] = v.Sha1
+		}
+	}
+	return names
+}
+
+// 删除索引中指定位置的版本
+func DeleteIndexVersionsAt(indexes []int) {
+	deleted := map[int]bool{}
+	for _, i := range indexes {
+		deleted[i] = true
+	}
+	var versions []Version
+	for i, v := range GetIndexVersions() {
+		if !deleted[i] {
+			versions = append(versions, ParseVersion(v))
+		}
+	}
+	WriteIndex(versions)
+}
+
+// 查找时间戳不晚于t的最新版本，返回其在索引中的位置，不存在时返回-1。
+// 时间戳相同时取索引中靠后的版本
+func FindIndexVersionAt(t time.Time) int {
+	r := -1
+	var latest time.Time
+	for i, v := range GetIndexVersions() {
+		ts, err := time.Parse(ISO8601, ParseVersion(v).Timestamp)
+		if err != nil || ts.After(t) {
+			continue
+		}
+		if r < 0 || !ts.Before(latest) {
+			r, latest = i, ts
+		}
+	}
+	return r
+}
+
+// 重写索引文件，先写入临时文件再替换，避免中断时损坏索引
+func WriteIndex(versions []Version) {
+	f, err := os.OpenFile("index.tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		Errorf("WriteIndex: %v", err)
+	}
+	for _, v := range versions {
+		if _, err := f.WriteString(StringifyVersion(v)); err != nil {
+			Errorf("WriteIndex: %v", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		Errorf("WriteIndex: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		Errorf("WriteIndex: %v", err)
+	}
+	if err := os.Rename("index.tmp", "index"); err != nil {
+		Errorf("WriteIndex: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package mvb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// 对象文件权限，只读，避免通过链接修改对象
+const OBJECT_MODE = 0444
+
+// 链接或还原对象的方式
+const (
+	LINK_COPY             = "copy"             // 复制
+	LINK_SYMLINK          = "symlink"          // 绝对路径的符号链接
+	LINK_SYMLINK_RELATIVE = "symlink-relative" // 相对路径的符号链接，备份存储空间与链接一起移动时仍然有效
+	LINK_HARDLINK         = "hardlink"         // 硬链接，需与备份存储空间在同一文件系统
+	LINK_REFLINK          = "reflink"          // 写时复制（FICLONE），需与备份存储空间在同一支持reflink的文件系统
+)
+
+func MakeObjectReadOnly(objectSha1 string) {
+	if err := os.Chmod(GetObjectPath(objectSha1), OBJECT_MODE); err != nil {
+		Errorf("MakeObjectReadOnly: %v", err)
+	}
+}
+
+// 按指定方式在dst创建对象的链接，dst不能已存在。硬链接及符号链接会先将对象设为只读
+func LinkObject(objectSha1 string, dst string, mode string) error {
+	object := GetObjectPath(objectSha1)
+	switch mode {
+	case LINK_SYMLINK:
+		MakeObjectReadOnly(objectSha1)
+		abs, err := filepath.Abs(object)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(abs, dst)
+	case LINK_SYMLINK_RELATIVE:
+		MakeObjectReadOnly(objectSha1)
+		abs, err := filepath.Abs(object)
+		if err != nil {
+			return err
+		}
+		dir, err := filepath.Abs(filepath.Dir(dst))
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, abs)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(rel, dst)
+	case LINK_HARDLINK:
+		MakeObjectReadOnly(objectSha1)
+		return replaceWith(dst, func(tmp string) error {
+			return os.Link(object, tmp)
+		})
+	case LINK_REFLINK:
+		return replaceWith(dst, func(tmp string) error {
+			return reflinkFile(object, tmp)
+		})
+	}
+	return fmt.Errorf("不支持的链接方式：%s", mode)
+}
+
+// 在dst所在文件夹中创建临时文件后重命名为dst，create失败时删除临时文件
+func replaceWith(dst string, create func(tmp string) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".mvb-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	if err := os.Remove(tmp); err != nil {
+		return err
+	}
+	if err := create(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package mvb
+
+import (
+	"errors"
+	"time"
+)
+
+// 备份存储空间的锁文件。保存对象及版本（backup、import、watch）与文件回收（gc）互斥，
+// 避免gc删除尚未被版本引用的新对象及正在写入的临时文件
+const REPOSITORY_LOCK_FILE = "repository.lock"
+
+var errLocked = errors.New("已被其他进程锁定")
+
+// 锁定备份存储空间，已被其他进程锁定时等待其解锁，返回解锁函数
+func LockRepository() func() {
+	waiting := false
+	for {
+		f, err := lockFile(REPOSITORY_LOCK_FILE)
+		if err == nil {
+			return func() { f.Close() }
+		}
+		if err != errLocked {
+			Errorf("LockRepository: %v", err)
+		}
+		if !waiting {
+			Verbosef("等待其他进程完成：%s\n", REPOSITORY_LOCK_FILE)
+			waiting = true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
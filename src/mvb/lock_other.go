@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package mvb
+
+import (
+	"os"
+	"syscall"
+)
+
+// 以独占的文件锁打开锁文件，已被其他进程锁定时返回错误。进程退出时锁自动释放
+func lockFile(name string) (*os.File, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
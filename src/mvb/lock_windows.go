@@ -0,0 +1,26 @@
+package mvb
+
+import (
+	"os"
+	"syscall"
+)
+
+// syscall中未定义ERROR_SHARING_VIOLATION
+const errorSharingViolation syscall.Errno = 32
+
+// 以不共享的方式打开锁文件，已被其他进程打开时返回错误。进程退出时文件自动关闭
+func lockFile(name string) (*os.File, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if err == errorSharingViolation {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), name), nil
+}
@@ -0,0 +1,72 @@
+package mvb
+
+import (
+	"strings"
+)
+
+type VersionChange struct {
+	Version
+	Changes []DiffFileMetadata
+	Before  []FileMetadata // 上一版本中该路径下的文件
+}
+
+// 取快照中指定路径的文件，path以/结尾时取该文件夹及其下所有文件及文件夹
+func filterFiles(files []FileMetadata, path string) []FileMetadata {
+	if !strings.HasSuffix(path, "/") {
+		if f := SearchFile(files, path); f != nil {
+			return []FileMetadata{*f}
+		}
+		return nil
+	}
+	var r []FileMetadata
+	for _, f := range files {
+		if strings.HasPrefix(f.Path, path) {
+			r = append(r, f)
+		}
+	}
+	return r
+}
+
+// 按时间倒序遍历所有版本，返回指定路径发生变化的版本及变化内容，结果按时间正序排列。
+// follow为true时，与git log --follow相同，从最新版本中的路径向前跟踪：文件在某版本中新增，
+// 且上一版本中存在SHA1相同、在该版本中被删除的文件，则视为重命名并继续跟踪原路径；
+// 路径为文件夹时，检测文件夹内SHA1相同的重命名
+func GetPathHistory(path string, follow bool) []VersionChange {
+	versions := GetIndexVersions()
+	var history []VersionChange
+	var files []FileMetadata
+	if len(versions) > 0 {
+		files = GetVersionFiles(ParseVersion(versions[len(versions)-1]).Sha1)
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		var prevFiles []FileMetadata
+		if i > 0 {
+			prevFiles = GetVersionFiles(ParseVersion(versions[i-1]).Sha1)
+		}
+		cur := filterFiles(files, path)
+		prev := filterFiles(prevFiles, path)
+		diffs := DiffFiles(prev, cur)
+
+		if follow && strings.HasSuffix(path, "/") {
+			diffs = DetectRenames(diffs, 0, nil, nil)
+		} else if follow && len(prev) == 0 && len(cur) == 1 {
+			for _, f := range prevFiles {
+				if f.Sha1 == cur[0].Sha1 && !strings.HasSuffix(f.Path, "/") && SearchFile(files, f.Path) == nil {
+					diffs = []DiffFileMetadata{{Type: "R", OldPath: f.Path, Similarity: 100, FileMetadata: cur[0]}}
+					path = f.Path
+					prev = []FileMetadata{f}
+					break
+				}
+			}
+		}
+
+		if len(diffs) > 0 {
+			history = append(history, VersionChange{Version: ParseVersion(versions[i]), Changes: diffs, Before: prev})
+		}
+		files = prevFiles
+	}
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history
+}
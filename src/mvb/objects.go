@@ -1,6 +1,10 @@
 package mvb
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
@@ -11,6 +15,16 @@ import (
 	"io/ioutil"
 )
 
+// 保存对象时先写入objects下的临时文件，完成后再重命名为对象
+const OBJECT_TEMP_IMPORT = "import-"
+const OBJECT_TEMP_COPY = "copy-"
+
+// p为objects下的相对路径，是否为保存对象时的临时文件
+func IsObjectTempFile(p string) bool {
+	p = filepath.ToSlash(p)
+	return !strings.Contains(p, "/") && (strings.HasPrefix(p, OBJECT_TEMP_IMPORT) || strings.HasPrefix(p, OBJECT_TEMP_COPY))
+}
+
 func IsObjectExist(objectSha1 string) bool {
 	if _, err := os.Stat(GetObjectPath(objectSha1)); err != nil {
 		if os.IsNotExist(err) {
@@ -47,15 +61,43 @@ func CopyObject(file *FileMetadata) {
 		return
 	}
 
+	// 与ImportObject相同，先复制到临时文件，设为只读后再重命名。内容相同的文件可能同时复制，
+	// 直接写入对象会打开另一个协程已设为只读的对象而失败
+	if err := os.MkdirAll("objects", os.ModeDir|0774); err != nil {
+		Errorf("CopyObject: %v", err)
+	}
+	w, err := ioutil.TempFile("objects", OBJECT_TEMP_COPY)
+	if err != nil {
+		Errorf("CopyObject: %v", err)
+	}
+	tmp := w.Name()
+	w.Close()
+
 	src := filepath.Join(GetRef(), file.Path)
 	dst := GetObjectPath(file.Sha1)
-	CopyFile(src, dst)
+	CopyFile(src, tmp)
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0774); err != nil {
+		os.Remove(tmp)
+		Errorf("CopyObject: %v", err)
+	}
+	if err := os.Chmod(tmp, OBJECT_MODE); err != nil {
+		os.Remove(tmp)
+		Errorf("CopyObject: %v", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		// 部分系统不能覆盖只读文件，对象已由其他协程保存时忽略
+		if !IsObjectExist(file.Sha1) {
+			Errorf("CopyObject: %v", err)
+		}
+	}
 
 	Verbosef("保存成功： %s\n", file.Path)
 }
 
-func WriteObjectTo(objectSha1 string, w *os.File) {
-	r, err := os.Open(GetObjectPath(objectSha1))
+// 输出对象内容，边输出边校验SHA1，内容与SHA1不一致时退出
+func WriteObjectTo(objectSha1 string, w io.Writer) {
+	r, err := OpenObject(objectSha1)
 	if err != nil {
 		Errorf("WriteObjectTo: %v", err)
 	}
@@ -66,12 +108,128 @@ func WriteObjectTo(objectSha1 string, w *os.File) {
 	}
 }
 
+// 校验SHA1的对象读取器，读取到结尾时内容与SHA1不一致，返回错误而不是io.EOF
+type objectReader struct {
+	file *os.File
+	sha1 string
+	hash hash.Hash
+}
+
+func OpenObject(objectSha1 string) (io.ReadCloser, error) {
+	f, err := os.Open(GetObjectPath(objectSha1))
+	if err != nil {
+		return nil, err
+	}
+	return &objectReader{file: f, sha1: objectSha1, hash: sha1.New()}, nil
+}
+
+func (r *objectReader) Close() error {
+	return r.file.Close()
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hash.Sum(nil)) != r.sha1 {
+		return n, fmt.Errorf("对象内容与SHA1不一致：%s", r.sha1)
+	}
+	return n, err
+}
+
+// 将对象内容写入dst，先写入同一文件夹中的临时文件，校验SHA1后再重命名，校验失败时重试一次。
+// mode为hardlink或reflink时先校验对象，再创建硬链接或写时复制副本，不支持时改为复制
+func ExtractObject(objectSha1 string, dst string, mode string) {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0755); err != nil {
+		Errorf("ExtractObject: %v", err)
+	}
+	if mode == LINK_HARDLINK || mode == LINK_REFLINK {
+		if err := VerifyObject(objectSha1); err != nil {
+			Errorf("还原失败：%s：%v", dst, err)
+		}
+		err := LinkObject(objectSha1, dst, mode)
+		if err == nil {
+			return
+		}
+		Verbosef("%v，改为复制：%s\n", err, dst)
+	}
+
+	err := extractObject(objectSha1, dst)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v，重试：%s\n", err, dst)
+		err = extractObject(objectSha1, dst)
+	}
+	if err != nil {
+		Errorf("还原失败：%s：%v", dst, err)
+	}
+}
+
+// 读取对象内容校验SHA1
+func VerifyObject(objectSha1 string) error {
+	r, err := OpenObject(objectSha1)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// 从不打开已存在的目标文件写入：目标文件可能是对象或其他文件的硬链接（如以hardlink方式还原），
+// 写入会修改对象；中途失败也不会留下写了一半的目标文件。目标文件已存在时，新文件沿用其权限及所有者
+func extractObject(objectSha1 string, dst string) error {
+	r, err := OpenObject(objectSha1)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".mvb-")
+	if err != nil {
+		return err
+	}
+	tmp := w.Name()
+	_, err = io.Copy(w, r)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	if fi, e := os.Lstat(dst); e == nil && fi.Mode().IsRegular() {
+		if err == nil {
+			err = os.Chmod(tmp, fi.Mode().Perm())
+		}
+		if err == nil {
+			copyOwner(tmp, fi)
+		}
+	} else if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
 func GetVersionFiles(version string) (files []FileMetadata) {
-	data, err := ioutil.ReadFile(GetObjectPath(version))
+	files, err := ReadVersionFiles(version)
 	if err != nil {
 		Errorf("GetVersionFiles: %v", err)
 	}
-	return ParseVersionObject(string(data))
+	return files
+}
+
+// 读取版本快照并校验路径，快照被篡改或损坏时返回包含版本SHA1的错误
+func ReadVersionFiles(version string) ([]FileMetadata, error) {
+	data, err := ioutil.ReadFile(GetObjectPath(version))
+	if err != nil {
+		return nil, err
+	}
+	files, err := CheckVersionObject(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("版本快照不合法：%s：%v", version, err)
+	}
+	return files, nil
 }
 
 func WriteVersionObject(id string, snapshot string) {
@@ -79,7 +237,7 @@ func WriteVersionObject(id string, snapshot string) {
 	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|0774); err != nil {
 		Errorf("WriteVersionObject: %v", err)
 	}
-	if err := ioutil.WriteFile(path, []byte(snapshot), 0644); err != nil {
+	if err := ioutil.WriteFile(path, []byte(snapshot), OBJECT_MODE); err != nil {
 		Errorf("WriteVersionObject: %v", err)
 	}
 }
@@ -87,7 +245,7 @@ func WriteVersionObject(id string, snapshot string) {
 func FastGetFilesSha1(files []FileMetadata, sha1Files []FileMetadata) {
 	for i := range files {
 		f := SearchFile(sha1Files, files[i].Path)
-		if f != nil && f.ModTime == files[i].ModTime && f.Size == files[i].Size {
+		if f != nil && SameModTime(f.ModTime, files[i].ModTime) && f.Size == files[i].Size {
 			files[i].Sha1 = f.Sha1
 		}
 	}
@@ -134,6 +292,20 @@ func SearchFile(files []FileMetadata, path string) *FileMetadata {
 	return nil
 }
 
+// 取快照中文件夹dir下的直接下级文件及文件夹，dir为空时取根文件夹
+func ChildFiles(files []FileMetadata, dir string) []FileMetadata {
+	var r []FileMetadata
+	for _, f := range files {
+		if !strings.HasPrefix(f.Path, dir) || f.Path == dir {
+			continue
+		}
+		if !strings.Contains(strings.TrimSuffix(f.Path[len(dir):], "/"), "/") {
+			r = append(r, f)
+		}
+	}
+	return r
+}
+
 func DiffFiles(from []FileMetadata, to []FileMetadata) []DiffFileMetadata {
 	var diffFileObjects DiffFileMetadataSlice
 	for _, f := range to {
@@ -154,6 +326,28 @@ func DiffFiles(from []FileMetadata, to []FileMetadata) []DiffFileMetadata {
 	return diffFileObjects
 }
 
+// 比较两个快照时间戳是否为同一时刻，不同时区备份的相同时刻视为相同
+func SameModTime(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	ta, errA := time.Parse(ISO8601, a)
+	tb, errB := time.Parse(ISO8601, b)
+	return errA == nil && errB == nil && ta.Equal(tb)
+}
+
+// 比较SHA1相同但最后修改时间不同的文件及文件夹，类型为M
+func DiffModTimes(from []FileMetadata, to []FileMetadata) []DiffFileMetadata {
+	var diffFileObjects DiffFileMetadataSlice
+	for _, f := range to {
+		file := SearchFile(from, f.Path)
+		if file != nil && file.Sha1 == f.Sha1 && !SameModTime(file.ModTime, f.ModTime) {
+			diffFileObjects = append(diffFileObjects, DiffFileMetadata{Type: "M", FileMetadata: f})
+		}
+	}
+	return diffFileObjects
+}
+
 func CopyFile(src string, dst string) {
 	if err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0774); err != nil {
 		Errorf("Copy: %v", err)
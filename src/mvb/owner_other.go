@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package mvb
+
+import (
+	"os"
+	"syscall"
+)
+
+// 将fi的所有者设置到文件name，没有权限时忽略
+func copyOwner(name string, fi os.FileInfo) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		os.Chown(name, int(st.Uid), int(st.Gid))
+	}
+}
@@ -0,0 +1,9 @@
+package mvb
+
+import (
+	"os"
+)
+
+// Windows中不保留所有者，新文件使用所在文件夹继承的权限
+func copyOwner(name string, fi os.FileInfo) {
+}
@@ -0,0 +1,257 @@
+package mvb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// 差异超过此行数时不再计算最小差异，直接输出整个文件的删除及新增
+const MAX_DIFF_LINES = 4000
+
+type lineEdit struct {
+	Op byte // ' ' 相同，'-' 删除，'+' 新增
+	A  int  // 在a中的行号（从0开始），新增时为插入位置
+	B  int  // 在b中的行号（从0开始），删除时为插入位置
+}
+
+// 判断是否为二进制内容，与git相同，前8000字节中包含NUL即视为二进制
+func IsBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// 按行拆分，保留行尾换行符
+func SplitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+func diffLines(a, b []string) []lineEdit {
+	var edits []lineEdit
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		edits = append(edits, lineEdit{Op: ' ', A: prefix, B: prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits = append(edits, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix], prefix, prefix)...)
+
+	for i := suffix; i > 0; i-- {
+		edits = append(edits, lineEdit{Op: ' ', A: len(a) - i, B: len(b) - i})
+	}
+	return edits
+}
+
+// Myers差分算法，ao、bo为a、b在原文件中的起始行号
+func myersDiff(a, b []string, ao, bo int) []lineEdit {
+	n, m := len(a), len(b)
+	if n+m == 0 {
+		return nil
+	}
+
+	max := n + m
+	if max > MAX_DIFF_LINES {
+		max = MAX_DIFF_LINES
+	}
+	off := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+	}
+
+	var edits []lineEdit
+	if !found {
+		for i := range a {
+			edits = append(edits, lineEdit{Op: '-', A: ao + i, B: bo})
+		}
+		for i := range b {
+			edits = append(edits, lineEdit{Op: '+', A: ao + n, B: bo + i})
+		}
+		return edits
+	}
+
+	// 从终点回溯，得到倒序的编辑序列
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var pk int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := prev[pk+d-1]
+		py := px - pk
+		for x > px && y > py {
+			x--
+			y--
+			edits = append(edits, lineEdit{Op: ' ', A: ao + x, B: bo + y})
+		}
+		if x == px {
+			y--
+			edits = append(edits, lineEdit{Op: '+', A: ao + x, B: bo + y})
+		} else {
+			x--
+			edits = append(edits, lineEdit{Op: '-', A: ao + x, B: bo + y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, lineEdit{Op: ' ', A: ao + x, B: bo + y})
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+func writeLine(w io.Writer, op byte, line string) {
+	fmt.Fprintf(w, "%c%s", op, line)
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		fmt.Fprint(w, "\n\\ No newline at end of file\n")
+	}
+}
+
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// 输出统一格式差异，context为上下文行数
+func WriteUnifiedDiff(w io.Writer, nameA string, nameB string, a []byte, b []byte, context int) {
+	linesA := SplitLines(a)
+	linesB := SplitLines(b)
+	edits := diffLines(linesA, linesB)
+
+	var changes []int
+	for i, e := range edits {
+		if e.Op != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", nameA, nameB)
+	for i := 0; i < len(changes); {
+		start := changes[i] - context
+		if start < 0 {
+			start = 0
+		}
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*context+1 {
+			j++
+		}
+		end := changes[j] + context + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		countA, countB := 0, 0
+		for _, e := range edits[start:end] {
+			if e.Op != '+' {
+				countA++
+			}
+			if e.Op != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(edits[start].A, countA), hunkRange(edits[start].B, countB))
+		for _, e := range edits[start:end] {
+			if e.Op == '+' {
+				writeLine(w, e.Op, linesB[e.B])
+			} else {
+				writeLine(w, e.Op, linesA[e.A])
+			}
+		}
+		i = j + 1
+	}
+}
+
+// 输出文件差异，fileA、fileB为文件内容所在路径，为空表示文件不存在；二进制文件只输出提示。
+// pathA与pathB不同时为重命名，使用git扩展格式输出重命名信息
+func WritePatchTo(w io.Writer, pathA string, pathB string, fileA string, fileB string, context int) {
+	if err := WritePatch(w, pathA, pathB, fileA, fileB, context); err != nil {
+		Errorf("WritePatchTo: %v", err)
+	}
+}
+
+// 与WritePatchTo相同，但读取文件出错时返回错误而不退出
+func WritePatch(w io.Writer, pathA string, pathB string, fileA string, fileB string, context int) error {
+	var a, b []byte
+	nameA, nameB := "/dev/null", "/dev/null"
+	if fileA != "" {
+		data, err := ioutil.ReadFile(fileA)
+		if err != nil {
+			return err
+		}
+		a = data
+		nameA = "a/" + pathA
+	}
+	if fileB != "" {
+		data, err := ioutil.ReadFile(fileB)
+		if err != nil {
+			return err
+		}
+		b = data
+		nameB = "b/" + pathB
+	}
+
+	if pathA != pathB {
+		fmt.Fprintf(w, "diff --git a/%s b/%s\nrename from %s\nrename to %s\n", pathA, pathB, pathA, pathB)
+	}
+
+	if IsBinary(a) || IsBinary(b) {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", nameA, nameB)
+		return nil
+	}
+	WriteUnifiedDiff(w, nameA, nameB, a, b, context)
+	return nil
+}
@@ -0,0 +1,137 @@
+package mvb
+
+import (
+	"strings"
+	"time"
+)
+
+// 结构化输出记录，type字段区分记录类型，时间使用RFC 3339格式
+
+type VersionRecord struct {
+	Type      string     `json:"type"` // version
+	Index     int        `json:"index,omitempty"`
+	Sha1      string     `json:"sha1"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Files     *int       `json:"files,omitempty"`
+}
+
+type FileRecord struct {
+	Type    string    `json:"type"` // file 或 dir
+	Path    string    `json:"path"`
+	Sha1    string    `json:"sha1,omitempty"`
+	Size    *int64    `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime"`
+}
+
+type DiffRecord struct {
+	Type       string     `json:"type"`   // diff
+	Change     string     `json:"change"` // + - * R M
+	Path       string     `json:"path"`
+	OldPath    string     `json:"old_path,omitempty"`
+	Similarity int        `json:"similarity,omitempty"`
+	File       FileRecord `json:"file"`
+}
+
+type DiffStatRecord struct {
+	Type     string `json:"type"` // diff_stat
+	Dir      string `json:"dir"`
+	Added    int    `json:"added"`
+	Removed  int    `json:"removed"`
+	Modified int    `json:"modified"`
+	Renamed  int    `json:"renamed"`
+	Metadata int    `json:"metadata"`
+	Bytes    int64  `json:"bytes"`
+}
+
+type LogRecord struct {
+	Type    string          `json:"type"` // log
+	Version *VersionRecord  `json:"version"`
+	Changes []DiffRecord    `json:"changes,omitempty"`
+	Stat    *DiffStatRecord `json:"stat,omitempty"`
+}
+
+type FindRecord struct {
+	Type    string         `json:"type"` // find
+	Version *VersionRecord `json:"version"`
+	File    FileRecord     `json:"file"`
+}
+
+type GrepRecord struct {
+	Type    string         `json:"type"` // grep
+	Version *VersionRecord `json:"version"`
+	Path    string         `json:"path"`
+	Sha1    string         `json:"sha1"`
+	Line    int            `json:"line,omitempty"`
+	Text    string         `json:"text,omitempty"`
+}
+
+type CheckRecord struct {
+	Type     string         `json:"type"` // check_index、check_object 或 check_version
+	Message  string         `json:"message,omitempty"`
+	Path     string         `json:"path,omitempty"`
+	Version  *VersionRecord `json:"version,omitempty"`
+	OK       bool           `json:"ok"`
+	Error    string         `json:"error,omitempty"`
+	Missing  []FileRecord   `json:"missing,omitempty"`
+	Corrupt  []FileRecord   `json:"corrupt,omitempty"`
+	Mismatch []FileRecord   `json:"mismatch,omitempty"`
+}
+
+type ActionRecord struct {
+	Type    string         `json:"type"`   // gc、repair、index_rebuild 或 restore
+	Action  string         `json:"action"` // delete、resupply、drop、add、unfixable、create、overwrite、skip
+	Object  string         `json:"object,omitempty"`
+	Path    string         `json:"path,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Version *VersionRecord `json:"version,omitempty"`
+}
+
+func parseTimestamp(timestamp string) time.Time {
+	t, _ := time.Parse(ISO8601, timestamp)
+	return t
+}
+
+// index为版本在索引中的位置（从1开始），为0时不输出
+func NewVersionRecord(version Version, index int) *VersionRecord {
+	r := &VersionRecord{Type: "version", Index: index, Sha1: version.Sha1}
+	if version.Timestamp != "" {
+		t := parseTimestamp(version.Timestamp)
+		r.Timestamp = &t
+	}
+	return r
+}
+
+func NewFileRecord(file FileMetadata) FileRecord {
+	if strings.HasSuffix(file.Path, "/") {
+		return FileRecord{Type: "dir", Path: file.Path, ModTime: parseTimestamp(file.ModTime)}
+	}
+	size := ParseSize(file.Size)
+	return FileRecord{Type: "file", Path: file.Path, Sha1: file.Sha1, Size: &size, ModTime: parseTimestamp(file.ModTime)}
+}
+
+func NewFileRecords(files []FileMetadata) []FileRecord {
+	var r []FileRecord
+	for _, f := range files {
+		r = append(r, NewFileRecord(f))
+	}
+	return r
+}
+
+func NewDiffRecord(diff DiffFileMetadata) DiffRecord {
+	r := DiffRecord{Type: "diff", Change: diff.Type, Path: diff.Path, File: NewFileRecord(diff.FileMetadata)}
+	if diff.Type == "R" {
+		r.OldPath = diff.OldPath
+		r.Similarity = diff.Similarity
+	}
+	return r
+}
+
+func NewDiffStatRecord(stat DiffStat) DiffStatRecord {
+	return DiffStatRecord{Type: "diff_stat", Dir: stat.Dir, Added: stat.Added, Removed: stat.Removed,
+		Modified: stat.Modified, Renamed: stat.Renamed, Metadata: stat.Metadata, Bytes: stat.Bytes}
+}
+
+func NewVersionHealthRecord(h VersionHealth) CheckRecord {
+	return CheckRecord{Type: "check_version", Version: NewVersionRecord(h.Version, 0), OK: h.OK(), Error: h.Error,
+		Missing: NewFileRecords(h.Missing), Corrupt: NewFileRecords(h.Corrupt), Mismatch: NewFileRecords(h.Mismatch)}
+}
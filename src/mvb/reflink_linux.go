@@ -0,0 +1,31 @@
+package mvb
+
+import (
+	"os"
+	"syscall"
+)
+
+// ioctl FICLONE，见 linux/fs.h
+const FICLONE = 0x40049409
+
+// 使用FICLONE创建src的写时复制副本dst，文件系统不支持或不在同一文件系统时返回错误
+func reflinkFile(src string, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, w.Fd(), FICLONE, r.Fd())
+	if err := w.Close(); errno == 0 && err != nil {
+		return err
+	}
+	if errno != 0 {
+		return &os.PathError{Op: "reflink", Path: dst, Err: errno}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package mvb
+
+import (
+	"os"
+	"syscall"
+)
+
+func reflinkFile(src string, dst string) error {
+	return &os.PathError{Op: "reflink", Path: dst, Err: syscall.ENOTSUP}
+}
@@ -0,0 +1,238 @@
+package mvb
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// 内容相似度检测的文件对数上限，超过时只检测SHA1相同的重命名
+const MAX_RENAME_PAIRS = 10000
+
+// 检测重命名及移动，将SHA1相同的删除及新增文件合并为R。
+// minSimilarity大于0时，同时将内容相似度不低于该百分比的文本文件合并为R，readA、readB用于读取比较双方的文件内容
+func DetectRenames(diffs []DiffFileMetadata, minSimilarity int, readA, readB func(f FileMetadata) []byte) []DiffFileMetadata {
+	var removed, added []int
+	removedSha1 := map[string][]int{}
+	for i, d := range diffs {
+		if strings.HasSuffix(d.Path, "/") {
+			continue
+		}
+		if d.Type == "-" {
+			removed = append(removed, i)
+			removedSha1[d.Sha1] = append(removedSha1[d.Sha1], i)
+		} else if d.Type == "+" {
+			added = append(added, i)
+		}
+	}
+
+	// 新增文件下标到删除文件下标
+	renames := map[int]int{}
+	similarity := map[int]int{}
+	used := map[int]bool{}
+	for _, i := range added {
+		best := -1
+		for _, j := range removedSha1[diffs[i].Sha1] {
+			if used[j] {
+				continue
+			}
+			if best < 0 || path.Base(diffs[j].Path) == path.Base(diffs[i].Path) && path.Base(diffs[best].Path) != path.Base(diffs[i].Path) {
+				best = j
+			}
+		}
+		if best >= 0 {
+			renames[i] = best
+			similarity[i] = 100
+			used[best] = true
+		}
+	}
+
+	if minSimilarity > 0 && minSimilarity < 100 {
+		var restA, restB []int
+		for _, j := range removed {
+			if !used[j] {
+				restA = append(restA, j)
+			}
+		}
+		for _, i := range added {
+			if _, ok := renames[i]; !ok {
+				restB = append(restB, i)
+			}
+		}
+		if len(restA)*len(restB) > MAX_RENAME_PAIRS {
+			Verbosef("文件过多，跳过相似度检测：%d x %d\n", len(restA), len(restB))
+		} else {
+			for _, p := range findSimilarFiles(diffs, restA, restB, minSimilarity, readA, readB) {
+				renames[p.b] = p.a
+				similarity[p.b] = p.score
+				used[p.a] = true
+			}
+		}
+	}
+
+	var r DiffFileMetadataSlice
+	for i, d := range diffs {
+		if used[i] {
+			continue
+		}
+		if j, ok := renames[i]; ok {
+			d.Type = "R"
+			d.OldPath = diffs[j].Path
+			d.Similarity = similarity[i]
+		}
+		r = append(r, d)
+	}
+	sort.Sort(r)
+	return r
+}
+
+type similarPair struct {
+	a, b, score int
+}
+
+func findSimilarFiles(diffs []DiffFileMetadata, removed []int, added []int, minSimilarity int, readA, readB func(f FileMetadata) []byte) []similarPair {
+	contents := map[int][]string{}
+	load := func(i int, read func(f FileMetadata) []byte) []string {
+		if lines, ok := contents[i]; ok {
+			return lines
+		}
+		data := read(diffs[i].FileMetadata)
+		var lines []string
+		if !IsBinary(data) {
+			lines = SplitLines(data)
+		}
+		contents[i] = lines
+		return lines
+	}
+
+	var pairs []similarPair
+	for _, j := range removed {
+		a := load(j, readA)
+		if len(a) == 0 {
+			continue
+		}
+		for _, i := range added {
+			b := load(i, readB)
+			if len(b) == 0 {
+				continue
+			}
+			// 行数相差过大时不可能达到相似度要求
+			n := len(a)
+			if len(b) < n {
+				n = len(b)
+			}
+			if n*200 < minSimilarity*(len(a)+len(b)) {
+				continue
+			}
+			common := 0
+			for _, e := range diffLines(a, b) {
+				if e.Op == ' ' {
+					common++
+				}
+			}
+			if score := common * 200 / (len(a) + len(b)); score >= minSimilarity {
+				pairs = append(pairs, similarPair{a: j, b: i, score: score})
+			}
+		}
+	}
+
+	// 优先匹配相似度最高的文件对
+	sort.SliceStable(pairs, func(x, y int) bool { return pairs[x].score > pairs[y].score })
+	var r []similarPair
+	usedA := map[int]bool{}
+	usedB := map[int]bool{}
+	for _, p := range pairs {
+		if usedA[p.a] || usedB[p.b] {
+			continue
+		}
+		usedA[p.a] = true
+		usedB[p.b] = true
+		r = append(r, p)
+	}
+	return r
+}
+
+// 将整个文件夹的移动合并为一条记录。文件夹内所有文件均为完全相同的重命名，
+// 且新旧文件夹内的文件一一对应时，才视为文件夹移动
+func CollapseDirectoryRenames(diffs []DiffFileMetadata) []DiffFileMetadata {
+	added := map[string]int{}
+	for i, d := range diffs {
+		if d.Type == "+" && strings.HasSuffix(d.Path, "/") {
+			added[d.Path] = i
+		}
+	}
+
+	skip := map[int]bool{}
+	collapsed := map[int]DiffFileMetadata{}
+	for i, d := range diffs {
+		if skip[i] || d.Type != "-" || !strings.HasSuffix(d.Path, "/") {
+			continue
+		}
+
+		// 由任一重命名的子文件推算新文件夹
+		target := ""
+		for _, c := range diffs {
+			if c.Type == "R" && strings.HasPrefix(c.OldPath, d.Path) {
+				rel := c.OldPath[len(d.Path):]
+				if strings.HasSuffix(c.Path, "/"+rel) {
+					target = c.Path[:len(c.Path)-len(rel)]
+				}
+				break
+			}
+		}
+		j, ok := added[target]
+		if !ok || skip[j] {
+			continue
+		}
+
+		inner := map[int]bool{j: true}
+		moved := true
+		for k, c := range diffs {
+			if k == i || !strings.HasPrefix(c.Path, d.Path) && !(c.Type == "R" && strings.HasPrefix(c.OldPath, d.Path)) {
+				continue
+			}
+			switch {
+			case c.Type == "R" && c.Similarity == 100 && strings.HasPrefix(c.OldPath, d.Path) && c.Path == target+c.OldPath[len(d.Path):]:
+				inner[k] = true
+			case c.Type == "-" && strings.HasSuffix(c.Path, "/"):
+				if a, ok := added[target+c.Path[len(d.Path):]]; ok {
+					inner[k] = true
+					inner[a] = true
+				} else {
+					moved = false
+				}
+			default:
+				moved = false
+			}
+		}
+		for k, c := range diffs {
+			if k != j && strings.HasPrefix(c.Path, target) && !inner[k] {
+				moved = false
+			}
+		}
+		if !moved {
+			continue
+		}
+
+		for k := range inner {
+			skip[k] = true
+		}
+		d.Type = "R"
+		d.OldPath = d.Path
+		d.Path = target
+		d.ModTime = diffs[j].ModTime
+		d.Similarity = 100
+		collapsed[i] = d
+	}
+
+	var r DiffFileMetadataSlice
+	for i, d := range diffs {
+		if c, ok := collapsed[i]; ok {
+			r = append(r, c)
+		} else if !skip[i] {
+			r = append(r, d)
+		}
+	}
+	sort.Sort(r)
+	return r
+}
@@ -0,0 +1,149 @@
+package mvb
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 在源文件夹中查找指定SHA1的文件，返回对象SHA1到源文件路径的映射。
+// 最新版本的快照可能正是需要修复的对象，因此不借用其中的SHA1，全部重新计算
+func FindRefObjects(objects map[string]bool) map[string]string {
+	found := map[string]string{}
+	if len(objects) == 0 {
+		return found
+	}
+	root := GetRef()
+	files := GetFiles(root)
+	GetFilesSha1(root, files)
+	for _, f := range files {
+		if _, ok := objects[f.Sha1]; ok {
+			found[f.Sha1] = filepath.Join(root, f.Path)
+		}
+	}
+	return found
+}
+
+// 使用源文件重新保存对象，写入临时文件并校验SHA1后替换原对象
+func RepairObject(objectSha1 string, src string) bool {
+	dst := GetObjectPath(objectSha1)
+	tmp := dst + ".tmp"
+	CopyFile(src, tmp)
+	if GetFileSha1(tmp) != objectSha1 {
+		Verbosef("源文件已变化：%s\n", src)
+		os.Remove(tmp)
+		return false
+	}
+	if err := os.Chmod(tmp, OBJECT_MODE); err != nil {
+		Errorf("RepairObject: %v", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		Errorf("RepairObject: %v", err)
+	}
+	return true
+}
+
+// 读取对象并判断是否为版本快照，非快照对象只读取第一行
+func ReadSnapshotObject(objectSha1 string) ([]FileMetadata, bool) {
+	f, err := os.Open(GetObjectPath(objectSha1))
+	if err != nil {
+		Errorf("ReadSnapshotObject: %v", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 4*1024)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, false
+	}
+	head = head[:n]
+	i := strings.IndexByte(string(head), '\n')
+	if i < 0 || CheckFileMetadataRecord(string(head[:i])) != nil {
+		return nil, false
+	}
+
+	rest, err := ioutil.ReadAll(f)
+	if err != nil {
+		Errorf("ReadSnapshotObject: %v", err)
+	}
+	files, err := CheckVersionObject(string(head) + string(rest))
+	if err != nil {
+		return nil, false
+	}
+	return files, true
+}
+
+// 推算快照的备份时间。快照对象在备份时写入，其修改时间即为备份时间；
+// 但objects被拷贝过时修改时间可能不准确，此时取快照中最新的文件修改时间
+func GuessSnapshotTimestamp(objectSha1 string, files []FileMetadata) string {
+	var latest time.Time
+	for _, f := range files {
+		if t, err := time.Parse(ISO8601, f.ModTime); err == nil && t.After(latest) {
+			latest = t
+		}
+	}
+	if fi, err := os.Stat(GetObjectPath(objectSha1)); err == nil && !fi.ModTime().Before(latest) {
+		latest = fi.ModTime()
+	}
+	return latest.Format(ISO8601)
+}
+
+// 扫描objects，找出所有版本快照对象。被其他快照作为文件引用的对象（如备份的mvb备份文件夹中的快照）不视为版本快照
+func FindSnapshotObjects() []Version {
+	snapshots := map[string][]FileMetadata{}
+	referenced := map[string]bool{}
+
+	err := filepath.Walk("objects", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		p, err := filepath.Rel("objects", path)
+		if err != nil {
+			return err
+		}
+		p = filepath.ToSlash(p)
+		if len(p) != 41 || p[2] != '/' || !IsSha1(p[:2]+p[3:]) {
+			return nil
+		}
+
+		s := p[:2] + p[3:]
+		if files, ok := ReadSnapshotObject(s); ok {
+			Verbosef("发现快照：%s\n", s)
+			snapshots[s] = files
+			for _, f := range files {
+				referenced[f.Sha1] = true
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		Errorf("FindSnapshotObjects: %v", err)
+	}
+
+	var versions []Version
+	for s, files := range snapshots {
+		if referenced[s] {
+			continue
+		}
+		versions = append(versions, Version{Sha1: s, Timestamp: GuessSnapshotTimestamp(s, files)})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Sha1 < versions[j].Sha1 })
+	SortVersions(versions)
+	return versions
+}
+
+// 按时间戳正序排序版本
+func SortVersions(versions []Version) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, _ := time.Parse(ISO8601, versions[i].Timestamp)
+		b, _ := time.Parse(ISO8601, versions[j].Timestamp)
+		return a.Before(b)
+	})
+}
@@ -0,0 +1,348 @@
+package mvb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 覆盖已存在文件的策略
+const (
+	OVERWRITE_ALWAYS     = "always"     // 覆盖所有文件，包括内容相同的文件
+	OVERWRITE_NEVER      = "never"      // 不覆盖已存在的文件
+	OVERWRITE_IF_NEWER   = "if-newer"   // 版本中文件的最后修改时间晚于目标文件时覆盖
+	OVERWRITE_IF_CHANGED = "if-changed" // 文件内容不同时覆盖
+)
+
+// 目标文件夹中符号链接的SHA1，不与任何文件相同
+const SYMLINK_SHA1 = "symlink"
+
+type RestoreOptions struct {
+	Patterns     []string // 只还原匹配的路径，参见MatchPath，为空时还原所有路径
+	NoDelete     bool     // 不删除版本中不存在的文件及文件夹
+	Overwrite    string   // 覆盖策略
+	BackupSuffix string   // 覆盖或删除文件前，将原文件重命名为加上此后缀的文件，为空时不保留
+	Mode         string   // 还原文件的方式：copy、hardlink、reflink
+}
+
+type RestoreAction struct {
+	Action  string // create、overwrite、delete、metadata、skip，校验时为mismatch、missing
+	File    FileMetadata
+	Message string // 跳过的原因
+}
+
+type RestoreActionSlice []RestoreAction
+
+func (s RestoreActionSlice) Len() int           { return len(s) }
+func (s RestoreActionSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s RestoreActionSlice) Less(i, j int) bool { return s[i].File.Path < s[j].File.Path }
+
+func NewRestoreOptions() *RestoreOptions {
+	return &RestoreOptions{Overwrite: OVERWRITE_IF_CHANGED, Mode: LINK_COPY}
+}
+
+func filterRestoreFiles(files []FileMetadata, patterns []string) []FileMetadata {
+	var r []FileMetadata
+	for _, f := range files {
+		if MatchPaths(patterns, f.Path) {
+			r = append(r, f)
+		}
+	}
+	return r
+}
+
+// 快照中文件夹dir下的所有文件及文件夹，files需按路径排序
+func descendantFiles(files []FileMetadata, dir string) []FileMetadata {
+	start := sort.Search(len(files), func(i int) bool { return files[i].Path > dir })
+	end := start + sort.Search(len(files)-start, func(i int) bool { return !strings.HasPrefix(files[start+i].Path, dir) })
+	return files[start:end]
+}
+
+// 比较目标文件夹与版本，按路径顺序生成还原操作，目标文件夹不存在时视为空文件夹。
+// 目标文件夹中多余的文件夹，只有其下所有文件及文件夹都被删除时才删除；指定了BackupSuffix时整个文件夹重命名保留。
+// 路径在目标文件夹与版本中一个是文件一个是文件夹时，先删除原路径，原路径不能删除时跳过
+func PlanRestore(root string, files []FileMetadata, options *RestoreOptions) []RestoreAction {
+	var all []FileMetadata
+	if _, err := os.Lstat(root); !os.IsNotExist(err) {
+		all = GetFiles(root)
+	}
+	src := filterRestoreFiles(all, options.Patterns)
+	dst := filterRestoreFiles(files, options.Patterns)
+
+	FastGetFilesSha1(src, dst)
+	// 目标文件夹中的符号链接不读取其指向的内容，视为与版本中的文件不同，还原时替换链接本身
+	for i := range src {
+		if !strings.HasSuffix(src[i].Path, "/") && isSymlink(filepath.Join(root, src[i].Path)) {
+			src[i].Sha1 = SYMLINK_SHA1
+		}
+	}
+	GetFilesSha1(root, src)
+
+	diffs := DiffFiles(src, dst)
+	if options.Overwrite != OVERWRITE_NEVER {
+		diffs = append(diffs, DiffModTimes(src, dst)...)
+		sort.Sort(DiffFileMetadataSlice(diffs))
+	}
+
+	removed := map[string]bool{}
+	for i := len(diffs) - 1; i >= 0; i-- {
+		f := diffs[i]
+		if f.Type != "-" || options.NoDelete {
+			continue
+		}
+		if !strings.HasSuffix(f.Path, "/") {
+			removed[f.Path] = options.BackupSuffix == "" || !strings.HasSuffix(f.Path, options.BackupSuffix)
+			continue
+		}
+		removed[f.Path] = true
+		if options.BackupSuffix == "" {
+			for _, d := range descendantFiles(all, f.Path) {
+				if !removed[d.Path] {
+					removed[f.Path] = false
+					break
+				}
+			}
+		}
+	}
+
+	var actions []RestoreAction
+	var moved, blocked string // 整个重命名的文件夹、因类型冲突跳过的文件夹
+	for _, f := range diffs {
+		if moved != "" && strings.HasPrefix(f.Path, moved) {
+			continue
+		}
+		if blocked != "" && strings.HasPrefix(f.Path, blocked) {
+			actions = append(actions, RestoreAction{Action: "skip", File: f.FileMetadata, Message: "上级路径类型冲突"})
+			continue
+		}
+		dir := strings.HasSuffix(f.Path, "/")
+
+		switch f.Type {
+		case "+":
+			other := f.Path + "/"
+			if dir {
+				other = strings.TrimSuffix(f.Path, "/")
+			}
+			if SearchFile(all, other) != nil && !removed[other] {
+				actions = append(actions, RestoreAction{Action: "skip", File: f.FileMetadata, Message: "类型冲突"})
+				if dir {
+					blocked = f.Path
+				}
+				continue
+			}
+			actions = append(actions, RestoreAction{Action: "create", File: f.FileMetadata})
+		case "*":
+			actions = append(actions, planOverwrite(*SearchFile(src, f.Path), f.FileMetadata, "overwrite", options))
+		case "M":
+			actions = append(actions, planOverwrite(*SearchFile(src, f.Path), f.FileMetadata, "metadata", options))
+		case "-":
+			if !removed[f.Path] {
+				actions = append(actions, RestoreAction{Action: "skip", File: f.FileMetadata})
+				continue
+			}
+			actions = append(actions, RestoreAction{Action: "delete", File: f.FileMetadata})
+			if dir && options.BackupSuffix != "" {
+				moved = f.Path
+			}
+		}
+	}
+
+	if options.Overwrite == OVERWRITE_ALWAYS {
+		for _, f := range dst {
+			if t := SearchFile(src, f.Path); t != nil && t.Sha1 == f.Sha1 && SameModTime(t.ModTime, f.ModTime) && !strings.HasSuffix(f.Path, "/") {
+				actions = append(actions, RestoreAction{Action: "overwrite", File: f})
+			}
+		}
+		sort.Sort(RestoreActionSlice(actions))
+	}
+	return actions
+}
+
+func planOverwrite(target FileMetadata, file FileMetadata, action string, options *RestoreOptions) RestoreAction {
+	switch options.Overwrite {
+	case OVERWRITE_NEVER:
+		return RestoreAction{Action: "skip", File: file}
+	case OVERWRITE_ALWAYS:
+		if !strings.HasSuffix(file.Path, "/") {
+			return RestoreAction{Action: "overwrite", File: file}
+		}
+	case OVERWRITE_IF_NEWER:
+		t, _ := time.Parse(ISO8601, target.ModTime)
+		v, _ := time.Parse(ISO8601, file.ModTime)
+		if !v.After(t) {
+			return RestoreAction{Action: "skip", File: file}
+		}
+	}
+	return RestoreAction{Action: action, File: file}
+}
+
+// 执行还原操作。先按路径倒序删除，保证先删除文件夹中的文件；再按路径顺序新建文件夹、新建及覆盖文件；
+// 最后恢复版本中文件夹的最后修改时间，files为版本快照
+func ApplyRestore(root string, files []FileMetadata, actions []RestoreAction, options *RestoreOptions) {
+	target := &restoreTarget{root: root, dirs: map[string]bool{}}
+	if err := os.MkdirAll(root, os.ModeDir|0755); err != nil {
+		Errorf("新建文件夹失败：%v", err)
+	}
+	for i := len(actions) - 1; i >= 0; i-- {
+		a := actions[i]
+		if a.Action != "delete" {
+			continue
+		}
+		p := target.path(a.File.Path)
+		Verbosef("删除：%s\n", a.File.Path)
+		if options.BackupSuffix != "" && !isEmptyDir(p) {
+			backupRestoreFile(p, options.BackupSuffix)
+		} else if err := os.Remove(p); err != nil {
+			Errorf("删除失败：%v", err)
+		}
+	}
+
+	for _, a := range actions {
+		if a.Action != "create" && a.Action != "overwrite" && a.Action != "metadata" {
+			continue
+		}
+		p := target.path(a.File.Path)
+		symlink := isSymlink(p)
+		switch {
+		case a.Action == "create" && strings.HasSuffix(a.File.Path, "/"):
+			Verbosef("新建：%s\n", a.File.Path)
+			if err := os.MkdirAll(p, os.ModeDir|0755); err != nil {
+				Errorf("新建文件夹失败：%v", err)
+			}
+		case a.Action == "create" || a.Action == "overwrite":
+			if a.Action == "overwrite" && options.BackupSuffix != "" {
+				backupRestoreFile(p, options.BackupSuffix)
+			} else if symlink {
+				// 替换符号链接本身，不写入链接指向的文件
+				if err := os.Remove(p); err != nil {
+					Errorf("删除符号链接失败：%v", err)
+				}
+			}
+			Verbosef("还原：%s\n", a.File.Path)
+			ExtractObject(a.File.Sha1, p, options.Mode)
+			if !isObjectLink(p, a.File.Sha1) {
+				restoreModTime(p, a.File.ModTime)
+			}
+		case a.Action == "metadata" && !strings.HasSuffix(a.File.Path, "/") && !symlink && !isObjectLink(p, a.File.Sha1):
+			restoreModTime(p, a.File.ModTime)
+		}
+	}
+
+	// 文件夹中的内容变化会修改文件夹的最后修改时间，所以最后恢复
+	for _, f := range filterRestoreFiles(files, options.Patterns) {
+		if !strings.HasSuffix(f.Path, "/") {
+			continue
+		}
+		p := target.path(f.Path)
+		if fi, err := os.Lstat(p); err == nil && fi.IsDir() && fi.ModTime().Format(ISO8601) != f.ModTime {
+			restoreModTime(p, f.ModTime)
+		}
+	}
+}
+
+// 还原的目标文件夹。访问路径前确认各级上级文件夹都不是符号链接，避免通过符号链接写入目标文件夹之外
+type restoreTarget struct {
+	root string
+	dirs map[string]bool // 已确认不是符号链接的文件夹
+}
+
+func (t *restoreTarget) path(path string) string {
+	p := strings.TrimSuffix(path, "/")
+	for i := 0; i < len(p); i++ {
+		if p[i] != '/' || t.dirs[p[:i]] {
+			continue
+		}
+		dir := filepath.Join(t.root, filepath.FromSlash(p[:i]))
+		fi, err := os.Lstat(dir)
+		if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			Errorf("拒绝通过符号链接还原：%s", dir)
+		}
+		if err == nil && fi.IsDir() {
+			t.dirs[p[:i]] = true
+		}
+	}
+	return filepath.Join(t.root, filepath.FromSlash(p))
+}
+
+func isSymlink(path string) bool {
+	fi, err := os.Lstat(path)
+	return err == nil && fi.Mode()&os.ModeSymlink != 0
+}
+
+func isEmptyDir(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.IsDir() {
+		return false
+	}
+	_, err = f.Readdirnames(1)
+	return err == io.EOF
+}
+
+// 文件是否为对象的硬链接。硬链接与对象共享最后修改时间，修改会改变对象及其他版本中同一文件，
+// 也会改变之前还原的其他硬链接，所以硬链接不恢复最后修改时间
+func isObjectLink(path string, objectSha1 string) bool {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	oi, err := os.Stat(GetObjectPath(objectSha1))
+	return err == nil && os.SameFile(fi, oi)
+}
+
+func restoreModTime(path string, modTime string) {
+	t, err := time.Parse(ISO8601, modTime)
+	if err != nil {
+		return
+	}
+	if err := os.Chtimes(path, time.Now(), t); err != nil {
+		Errorf("恢复最后修改时间失败：%v", err)
+	}
+}
+
+func backupRestoreFile(path string, suffix string) {
+	Verbosef("保留：%s%s\n", strings.TrimSuffix(path, string(filepath.Separator)), suffix)
+	if err := os.Rename(path, strings.TrimSuffix(path, string(filepath.Separator))+suffix); err != nil {
+		Errorf("保留原文件失败：%v", err)
+	}
+}
+
+// 还原后重新计算目标文件夹中文件的SHA1，与版本快照比较，返回不一致及缺失的文件。跳过的文件不校验
+func VerifyRestore(root string, files []FileMetadata, actions []RestoreAction, options *RestoreOptions) []RestoreAction {
+	skipped := map[string]bool{}
+	for _, a := range actions {
+		if a.Action == "skip" {
+			skipped[a.File.Path] = true
+		}
+	}
+
+	var failures []RestoreAction
+	for _, f := range filterRestoreFiles(files, options.Patterns) {
+		if skipped[f.Path] {
+			continue
+		}
+		p := filepath.Join(root, f.Path)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			failures = append(failures, RestoreAction{Action: "missing", File: f, Message: err.Error()})
+			continue
+		}
+		if dir := strings.HasSuffix(f.Path, "/"); dir != fi.IsDir() || !fi.Mode().IsRegular() && !dir {
+			failures = append(failures, RestoreAction{Action: "mismatch", File: f, Message: "类型不一致"})
+			continue
+		} else if dir {
+			continue
+		}
+		Verbosef("校验：%s\n", f.Path)
+		if s := GetFileSha1(p); s != f.Sha1 {
+			failures = append(failures, RestoreAction{Action: "mismatch", File: f, Message: "SHA1不一致：" + s})
+		}
+	}
+	return failures
+}
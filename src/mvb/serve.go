@@ -0,0 +1,393 @@
+package mvb
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// 只读HTTP服务，提供网页及JSON接口，JSON记录格式与结构化输出相同：
+//
+//	/                          版本列表
+//	/browse/<版本>/<路径>      浏览文件夹或下载文件，文件支持Range
+//	/zip/<版本>/<文件夹>       将文件夹下载为zip
+//	/diff/<版本A>/<版本B>      版本差异，?path= 只比较匹配的路径，?patch=1 输出统一格式差异
+//	/api/versions              版本列表，倒序
+//	/api/versions/<版本>       版本快照，?path= 只列出以此路径开头的文件及文件夹
+//	/api/diff/<版本A>/<版本B>  版本差异，?path= 同上
+func NewServer() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", serveVersions)
+	mux.HandleFunc("/browse/", serveBrowse)
+	mux.HandleFunc("/zip/", serveZip)
+	mux.HandleFunc("/diff/", serveDiff)
+	mux.HandleFunc("/api/versions", serveAPIVersions)
+	mux.HandleFunc("/api/versions/", serveAPIVersion)
+	mux.HandleFunc("/api/diff/", serveAPIDiff)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Verbosef("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "只读服务", http.StatusMethodNotAllowed)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// 分割请求路径为版本及版本中的路径
+func splitVersionPath(p string, prefix string) (string, string) {
+	p = strings.TrimPrefix(p, prefix)
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+// 读取版本快照，版本不存在时输出错误并返回false
+func lookupVersionFiles(w http.ResponseWriter, pattern string) (Version, []FileMetadata, bool) {
+	version, err := LookupVersion(pattern)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return version, nil, false
+	}
+	files, err := ReadVersionFiles(version.Sha1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return version, nil, false
+	}
+	return version, files, true
+}
+
+// 比较两个版本，检测内容相同的重命名
+func diffVersions(w http.ResponseWriter, r *http.Request, prefix string) ([]FileMetadata, []DiffFileMetadata, bool) {
+	a, b := splitVersionPath(r.URL.Path, prefix)
+	b = strings.TrimSuffix(b, "/")
+	_, filesA, ok := lookupVersionFiles(w, a)
+	if !ok {
+		return nil, nil, false
+	}
+	_, filesB, ok := lookupVersionFiles(w, b)
+	if !ok {
+		return nil, nil, false
+	}
+
+	diffs := DetectRenames(DiffFiles(filesA, filesB), 0, nil, nil)
+	if p := r.URL.Query().Get("path"); p != "" {
+		var matched []DiffFileMetadata
+		for _, f := range diffs {
+			if MatchPath(p, f.Path) || f.Type == "R" && MatchPath(p, f.OldPath) {
+				matched = append(matched, f)
+			}
+		}
+		diffs = matched
+	}
+	return filesA, diffs, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+var serveTemplates = template.Must(template.New("").Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.}}</title>
+<style>body{font-family:sans-serif;margin:2em}td{padding:2px 12px 2px 0}.r{text-align:right}code{font-size:90%}</style>
+</head><body>{{end}}
+
+{{define "versions"}}{{template "head" "版本列表"}}
+<h1>版本列表</h1>
+<table>
+<tr><th>#</th><th>时间</th><th>SHA1</th><th></th></tr>
+{{range .}}<tr><td class="r">v{{.Index}}</td><td>{{.Time}}</td><td><a href="/browse/{{.Sha1}}/"><code>{{.Sha1}}</code></a></td>
+<td>{{if .Prev}}<a href="/diff/{{.Prev}}/{{.Sha1}}">与上一版本比较</a>{{end}}</td></tr>
+{{end}}</table>
+</body></html>{{end}}
+
+{{define "dir"}}{{template "head" .Path}}
+<h1><a href="/">版本列表</a> / <code>{{.Version}}</code> / {{.Path}}</h1>
+<p><a href="{{.Zip}}">下载zip</a>{{if .Parent}} · <a href="{{.Parent}}">上级文件夹</a>{{end}}</p>
+<table>
+<tr><th>名称</th><th>大小</th><th>最后修改时间</th></tr>
+{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td class="r">{{.Size}}</td><td>{{.ModTime}}</td></tr>
+{{end}}</table>
+</body></html>{{end}}
+
+{{define "diff"}}{{template "head" "版本差异"}}
+<h1><a href="/">版本列表</a> / <code>{{.A}}</code> → <code>{{.B}}</code></h1>
+<p><a href="{{.Patch}}">统一格式差异</a></p>
+<table>
+{{range .Changes}}<tr><td>{{.Type}}</td><td>{{if .Href}}<a href="{{.Href}}">{{.Path}}</a>{{else}}{{.Path}}{{end}}</td><td>{{.OldPath}}</td></tr>
+{{end}}</table>
+</body></html>{{end}}
+`))
+
+type versionEntry struct {
+	Index int
+	Time  string
+	Sha1  string
+	Prev  string
+}
+
+type dirEntry struct {
+	Name    string
+	Href    string
+	Size    string
+	ModTime string
+}
+
+type diffEntry struct {
+	Type    string
+	Path    string
+	OldPath string
+	Href    string
+}
+
+func formatTimestamp(timestamp string) string {
+	t, err := time.Parse(ISO8601, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func serveVersions(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	var entries []versionEntry
+	prev := ""
+	for i, s := range GetIndexVersions() {
+		v := ParseVersion(s)
+		entries = append(entries, versionEntry{Index: i + 1, Time: formatTimestamp(v.Timestamp), Sha1: v.Sha1, Prev: prev})
+		prev = v.Sha1
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	serveTemplates.ExecuteTemplate(w, "versions", entries)
+}
+
+func serveBrowse(w http.ResponseWriter, r *http.Request) {
+	pattern, p := splitVersionPath(r.URL.Path, "/browse/")
+	version, files, ok := lookupVersionFiles(w, pattern)
+	if !ok {
+		return
+	}
+
+	if p != "" && !strings.HasSuffix(p, "/") {
+		f := SearchFile(files, p)
+		if f == nil {
+			if SearchFile(files, p+"/") != nil {
+				http.Redirect(w, r, escapePath(r.URL.Path+"/"), http.StatusMovedPermanently)
+			} else {
+				http.NotFound(w, r)
+			}
+			return
+		}
+		serveObject(w, r, *f)
+		return
+	}
+	if p != "" && SearchFile(files, p) == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	base := "/browse/" + pattern + "/"
+	data := struct {
+		Version string
+		Path    string
+		Zip     string
+		Parent  string
+		Entries []dirEntry
+	}{Version: version.Sha1, Path: "/" + p, Zip: escapePath("/zip/" + pattern + "/" + p)}
+	if p != "" {
+		parent := path.Dir(strings.TrimSuffix(p, "/")) + "/"
+		if parent == "./" {
+			parent = ""
+		}
+		data.Parent = escapePath(base + parent)
+	}
+	for _, f := range ChildFiles(files, p) {
+		e := dirEntry{Name: f.Path[len(p):], Href: escapePath(base + f.Path), ModTime: formatTimestamp(f.ModTime)}
+		if !strings.HasSuffix(f.Path, "/") {
+			e.Size = strings.TrimSpace(f.Size)
+		}
+		data.Entries = append(data.Entries, e)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	serveTemplates.ExecuteTemplate(w, "dir", data)
+}
+
+// 输出文件内容，Content-Type根据文件名及内容判断，支持Range及条件请求
+func serveObject(w http.ResponseWriter, r *http.Request, file FileMetadata) {
+	f, err := os.Open(GetObjectPath(file.Sha1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	modTime, _ := time.Parse(ISO8601, file.ModTime)
+	w.Header().Set("ETag", `"`+file.Sha1+`"`)
+	http.ServeContent(w, r, path.Base(file.Path), modTime, f)
+}
+
+func serveZip(w http.ResponseWriter, r *http.Request) {
+	pattern, p := splitVersionPath(r.URL.Path, "/zip/")
+	version, files, ok := lookupVersionFiles(w, pattern)
+	if !ok {
+		return
+	}
+	if p != "" && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	if p != "" && SearchFile(files, p) == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := version.Sha1[:8]
+	if p != "" {
+		name = path.Base(p)
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(name+".zip"))
+	if r.Method == "HEAD" {
+		return
+	}
+	cw := &countWriter{w: w}
+	if err := WriteArchive(cw, files, p, "zip"); err != nil {
+		if cw.n > 0 {
+			// 已开始输出时无法再返回错误状态，中断连接，避免客户端把不完整的归档当作成功下载
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Del("Content-Disposition")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// 记录已写入的字节数
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func serveDiff(w http.ResponseWriter, r *http.Request) {
+	filesA, diffs, ok := diffVersions(w, r, "/diff/")
+	if !ok {
+		return
+	}
+	a, b := splitVersionPath(r.URL.Path, "/diff/")
+	b = strings.TrimSuffix(b, "/")
+
+	if r.URL.Query().Get("patch") != "" {
+		// 先生成完整的差异，读取对象出错时可以返回错误状态
+		var patch bytes.Buffer
+		for _, f := range diffs {
+			if strings.HasSuffix(f.Path, "/") || f.Type == "M" {
+				continue
+			}
+			oldPath, fileA, fileB := f.Path, "", ""
+			if f.Type == "R" {
+				oldPath = f.OldPath
+			}
+			if o := SearchFile(filesA, oldPath); o != nil && f.Type != "+" {
+				fileA = GetObjectPath(o.Sha1)
+			}
+			if f.Type != "-" {
+				fileB = GetObjectPath(f.Sha1)
+			}
+			if err := WritePatch(&patch, oldPath, f.Path, fileA, fileB, 3); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		patch.WriteTo(w)
+		return
+	}
+
+	q := r.URL.Query()
+	q.Set("patch", "1")
+	data := struct {
+		A, B    string
+		Patch   string
+		Changes []diffEntry
+	}{A: a, B: b, Patch: escapePath(r.URL.Path) + "?" + q.Encode()}
+	for _, f := range diffs {
+		e := diffEntry{Type: f.Type, Path: f.Path, OldPath: f.OldPath}
+		if f.Type == "-" {
+			e.Href = escapePath("/browse/" + a + "/" + f.Path)
+		} else {
+			e.Href = escapePath("/browse/" + b + "/" + f.Path)
+		}
+		data.Changes = append(data.Changes, e)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	serveTemplates.ExecuteTemplate(w, "diff", data)
+}
+
+func serveAPIVersions(w http.ResponseWriter, r *http.Request) {
+	versions := GetIndexVersions()
+	records := []*VersionRecord{}
+	for i := len(versions) - 1; i >= 0; i-- {
+		records = append(records, NewVersionRecord(ParseVersion(versions[i]), i+1))
+	}
+	writeJSON(w, records)
+}
+
+func serveAPIVersion(w http.ResponseWriter, r *http.Request) {
+	pattern, _ := splitVersionPath(r.URL.Path, "/api/versions/")
+	_, files, ok := lookupVersionFiles(w, pattern)
+	if !ok {
+		return
+	}
+	prefix := r.URL.Query().Get("path")
+	records := []FileRecord{}
+	for _, f := range files {
+		if strings.HasPrefix(f.Path, prefix) {
+			records = append(records, NewFileRecord(f))
+		}
+	}
+	writeJSON(w, records)
+}
+
+func serveAPIDiff(w http.ResponseWriter, r *http.Request) {
+	_, diffs, ok := diffVersions(w, r, "/api/diff/")
+	if !ok {
+		return
+	}
+	records := []DiffRecord{}
+	for _, f := range diffs {
+		records = append(records, NewDiffRecord(f))
+	}
+	writeJSON(w, records)
+}
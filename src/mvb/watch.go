@@ -0,0 +1,308 @@
+package mvb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// 监视源文件夹的默认等待时间
+const WATCH_QUIET = 5 * time.Second
+const WATCH_MAX_INTERVAL = 5 * time.Minute
+
+// 监视源文件夹，变化停止Quiet时间后，或持续变化超过MaxInterval时创建新版本。
+// 新版本基于上一个快照，只重新读取及计算发生变化的路径
+type Watcher struct {
+	Quiet       time.Duration
+	MaxInterval time.Duration
+	OnVersion   func(Version) // 创建新版本后调用
+
+	root    string
+	files   []FileMetadata
+	dirty   map[string]bool // 发生变化的路径，文件夹不以/结尾
+	rescan  bool            // 事件溢出时重新扫描整个源文件夹
+	watcher *fsnotify.Watcher
+}
+
+func NewWatcher() *Watcher {
+	return &Watcher{Quiet: WATCH_QUIET, MaxInterval: WATCH_MAX_INTERVAL, dirty: map[string]bool{}}
+}
+
+// 开始监视，先备份一次当前状态，stop关闭时处理完尚未备份的变化后返回
+func (w *Watcher) Run(stop <-chan struct{}) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+	w.watcher = fw
+	w.root = GetRef()
+
+	// 先开始监视再扫描，扫描期间的变化之后再处理
+	if err := w.addDirs(w.root); err != nil {
+		return err
+	}
+	unlock := LockRepository()
+	w.files = GetRefFiles()
+	CopyObjects(w.files)
+	w.save(w.files)
+	unlock()
+
+	var first time.Time
+	var timeout <-chan time.Time
+	schedule := func() {
+		now := time.Now()
+		if first.IsZero() {
+			first = now
+		}
+		d := w.Quiet
+		if rest := first.Add(w.MaxInterval).Sub(now); rest < d {
+			d = rest
+		}
+		timeout = time.After(d)
+	}
+
+	for {
+		select {
+		case e, ok := <-fw.Events:
+			if !ok {
+				return nil
+			}
+			if w.handleEvent(e) {
+				schedule()
+			}
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return nil
+			}
+			if err != fsnotify.ErrEventOverflow {
+				return fmt.Errorf("监视失败：%v", err)
+			}
+			Verbosef("事件溢出，重新扫描源文件夹\n")
+			w.rescan = true
+			schedule()
+		case <-timeout:
+			first, timeout = time.Time{}, nil
+			w.snapshot()
+			if len(w.dirty) > 0 {
+				schedule()
+			}
+		case <-stop:
+			if w.rescan || len(w.dirty) > 0 {
+				w.snapshot()
+			}
+			return nil
+		}
+	}
+}
+
+// 监视dir及其下所有文件夹，fsnotify不会自动监视子文件夹
+func (w *Watcher) addDirs(dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if err := w.watcher.Add(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("监视失败：%s：%v", path, err)
+		}
+		return nil
+	})
+}
+
+// 记录发生变化的路径，返回是否需要创建新版本
+func (w *Watcher) handleEvent(e fsnotify.Event) bool {
+	p, err := filepath.Rel(w.root, e.Name)
+	if err != nil || p == "." {
+		return false
+	}
+	p = filepath.ToSlash(p)
+	Verbosef("变化：%s %s\n", e.Op, p)
+
+	w.dirty[p] = true
+	if e.Op&fsnotify.Create != 0 {
+		if fi, err := os.Lstat(e.Name); err == nil && fi.IsDir() {
+			if err := w.addDirs(e.Name); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+		}
+	}
+	return true
+}
+
+// 根据变化的路径生成新快照，与上一个快照不同时保存为新版本
+func (w *Watcher) snapshot() {
+	dirty := w.dirty
+	w.dirty = map[string]bool{}
+
+	var files []FileMetadata
+	if w.rescan {
+		// 溢出期间新建的文件夹没有被监视，重新添加。不知道哪些路径发生了变化，
+		// 与backup相同，最后修改时间及大小未变的文件沿用上一个快照中的SHA1
+		w.rescan = false
+		if err := w.addDirs(w.root); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		files = w.scan("")
+		FastGetFilesSha1(files, w.files)
+	} else {
+		// 未变化的路径已带有SHA1，变化的路径即使最后修改时间及大小未变也重新读取
+		files = w.refresh(dirty)
+	}
+	// 保存对象及版本期间锁定备份存储空间，gc不会删除尚未被版本引用的新对象
+	unlock := LockRepository()
+	defer unlock()
+	files = w.saveObjects(files)
+
+	if StringifyVersionObject(files) == StringifyVersionObject(w.files) {
+		Verbosef("没有变化\n")
+		return
+	}
+	w.files = files
+	w.save(files)
+}
+
+// 保存版本，版本已存在时（如启动时源文件夹与已有版本相同）不调用OnVersion
+func (w *Watcher) save(files []FileMetadata) {
+	timestamp := time.Now()
+	exist := IsObjectExist(Sha1([]byte(StringifyVersionObject(files))))
+	versionSha1 := SaveVersion(files, timestamp)
+	if w.OnVersion != nil && !exist {
+		w.OnVersion(Version{Sha1: versionSha1, Timestamp: timestamp.Format(ISO8601)})
+	}
+}
+
+// 保留上一个快照中未变化的路径，重新扫描变化的路径及其下所有文件，
+// 并更新其上级文件夹的最后修改时间
+func (w *Watcher) refresh(dirty map[string]bool) []FileMetadata {
+	isDirty := func(p string) bool {
+		for p != "." && p != "/" && p != "" {
+			if dirty[p] {
+				return true
+			}
+			p = filepath.ToSlash(filepath.Dir(p))
+		}
+		return false
+	}
+	parents := map[string]bool{}
+	for p := range dirty {
+		if d := filepath.ToSlash(filepath.Dir(p)); d != "." {
+			parents[d+"/"] = true
+		}
+	}
+
+	var files FileMetadataSlice
+	for _, f := range w.files {
+		if isDirty(strings.TrimSuffix(f.Path, "/")) {
+			continue
+		}
+		if parents[f.Path] {
+			fi, err := os.Lstat(filepath.Join(w.root, filepath.FromSlash(f.Path)))
+			if err != nil {
+				continue
+			}
+			f.ModTime = fi.ModTime().Format(ISO8601)
+		}
+		files = append(files, f)
+	}
+	for p := range dirty {
+		if !isDirty(filepath.ToSlash(filepath.Dir(p))) {
+			files = append(files, w.scan(p)...)
+		}
+	}
+	sort.Sort(files)
+	return files
+}
+
+// 扫描源文件夹中的路径p及其下所有文件，p为空时扫描整个源文件夹，路径不存在时返回空。
+// 无法读取的路径（如没有权限）输出错误后跳过，不中断监视
+func (w *Watcher) scan(p string) (files []FileMetadata) {
+	filepath.Walk(filepath.Join(w.root, filepath.FromSlash(p)), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "扫描失败，跳过：%v\n", err)
+			}
+			return nil
+		}
+		r, err := filepath.Rel(w.root, path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "扫描失败，跳过：%v\n", err)
+			return nil
+		}
+		if r == "." {
+			return nil
+		}
+		files = append(files, NewFileMetadata(filepath.ToSlash(r), fi))
+		return nil
+	})
+	if p == "" {
+		sort.Sort(FileMetadataSlice(files))
+	}
+	return files
+}
+
+// 保存新增或修改的文件，边读取边计算SHA1，避免计算后文件又被修改导致对象与SHA1不一致。
+// 保存前已被删除的文件从快照中去掉，并留待下次处理；无法读取的文件沿用上一个快照中的记录
+func (w *Watcher) saveObjects(files []FileMetadata) []FileMetadata {
+	var wg sync.WaitGroup
+	sem := make(chan int, MAX_GOS)
+	removed := make([]bool, len(files))
+	skipped := make([]bool, len(files))
+	for i := range files {
+		if files[i].Sha1 != "" {
+			continue
+		}
+		sem <- 1
+		wg.Add(1)
+		go func(i int) {
+			f := &files[i]
+			path := filepath.Join(w.root, filepath.FromSlash(f.Path))
+			r, err := os.Open(path)
+			if err != nil {
+				if _, e := os.Lstat(path); os.IsNotExist(e) {
+					removed[i] = true
+				} else {
+					fmt.Fprintf(os.Stderr, "保存失败，跳过：%s：%v\n", f.Path, err)
+					skipped[i] = true
+				}
+			} else {
+				var n int64
+				f.Sha1, n = ImportObject(r)
+				f.Size = fmt.Sprintf("%19d", n)
+				r.Close()
+				Verbosef("保存成功： %s\n", f.Path)
+			}
+			wg.Done()
+			<-sem
+		}(i)
+	}
+	wg.Wait()
+	close(sem)
+
+	var r []FileMetadata
+	for i, f := range files {
+		if skipped[i] {
+			if prev := SearchFile(w.files, f.Path); prev != nil {
+				r = append(r, *prev)
+			}
+			continue
+		}
+		if removed[i] {
+			w.dirty[f.Path] = true
+			continue
+		}
+		r = append(r, f)
+	}
+	return r
+}
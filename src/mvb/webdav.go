@@ -0,0 +1,286 @@
+package mvb
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// 只读WebDAV服务：
+//
+//	/versions/<版本>/...  所有版本，以时间戳命名，同一时间有多个版本时以SHA1命名；
+//	                      访问时版本也可以使用SHA1、v1、v-1等格式
+//	/latest/...           最新版本
+func NewWebDAVHandler() http.Handler {
+	h := &webdav.Handler{
+		FileSystem: &davFS{snapshots: map[string][]FileMetadata{}},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				Verbosef("%s %s %s %v\n", r.RemoteAddr, r.Method, r.URL, err)
+			}
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Verbosef("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS", "PROPFIND":
+			h.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS, PROPFIND")
+			http.Error(w, "只读服务", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+type davFS struct {
+	mu        sync.Mutex
+	snapshots map[string][]FileMetadata // 快照不会改变，读取后缓存
+
+	// 索引缓存，索引文件的最后修改时间或大小变化时重新读取
+	indexModTime time.Time
+	indexSize    int64
+	versions     []Version
+	names        []string
+}
+
+type davInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+	sha1    string
+}
+
+func (fi *davInfo) Name() string       { return fi.name }
+func (fi *davInfo) Size() int64        { return fi.size }
+func (fi *davInfo) ModTime() time.Time { return fi.modTime }
+func (fi *davInfo) IsDir() bool        { return fi.dir }
+func (fi *davInfo) Sys() interface{}   { return nil }
+
+func (fi *davInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+func newDavInfo(file FileMetadata) *davInfo {
+	t, _ := time.Parse(ISO8601, file.ModTime)
+	if strings.HasSuffix(file.Path, "/") {
+		return &davInfo{name: path.Base(file.Path), dir: true, modTime: t}
+	}
+	return &davInfo{name: path.Base(file.Path), size: ParseSize(file.Size), modTime: t, sha1: file.Sha1}
+}
+
+// 文件使用SHA1作为ETag，文件夹使用默认的ETag
+func (fi *davInfo) ETag(ctx context.Context) (string, error) {
+	if fi.sha1 == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + fi.sha1 + `"`, nil
+}
+
+// WebDAV中的节点，文件夹有children，文件有file
+type davNode struct {
+	info     *davInfo
+	children []os.FileInfo
+	file     *FileMetadata
+}
+
+func (fs *davFS) versionFiles(versionSha1 string) ([]FileMetadata, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if files, ok := fs.snapshots[versionSha1]; ok {
+		return files, nil
+	}
+	files, err := ReadVersionFiles(versionSha1)
+	if err != nil {
+		return nil, err
+	}
+	fs.snapshots[versionSha1] = files
+	return files, nil
+}
+
+// 所有版本及其在/versions/下的名称。每次请求都会多次调用，索引未变化时使用缓存
+func (fs *davFS) davVersions() ([]Version, []string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fi, err := os.Stat("index")
+	if err == nil && fs.versions != nil && fi.ModTime().Equal(fs.indexModTime) && fi.Size() == fs.indexSize {
+		return fs.versions, fs.names
+	}
+
+	versions := []Version{}
+	for _, s := range GetIndexVersions() {
+		versions = append(versions, ParseVersion(s))
+	}
+	fs.versions, fs.names = versions, NameVersions(versions)
+	fs.indexModTime, fs.indexSize = time.Time{}, 0
+	if err == nil {
+		fs.indexModTime, fs.indexSize = fi.ModTime(), fi.Size()
+	}
+	return fs.versions, fs.names
+}
+
+func (fs *davFS) find(name string) (*davNode, error) {
+	p := strings.Trim(path.Clean("/"+name), "/")
+	parts := strings.SplitN(p, "/", 3)
+	versions, names := fs.davVersions()
+
+	var version Version
+	var rest string
+	switch {
+	case p == "":
+		root := &davNode{info: &davInfo{name: "/", dir: true}}
+		root.children = append(root.children, &davInfo{name: "versions", dir: true})
+		if len(versions) > 0 {
+			latest := versions[len(versions)-1]
+			root.info.modTime = parseTimestamp(latest.Timestamp)
+			root.children = append(root.children, &davInfo{name: "latest", dir: true, modTime: root.info.modTime})
+		}
+		return root, nil
+	case parts[0] == "versions" && len(parts) == 1:
+		node := &davNode{info: &davInfo{name: "versions", dir: true}}
+		for i, v := range versions {
+			t := parseTimestamp(v.Timestamp)
+			node.children = append(node.children, &davInfo{name: names[i], dir: true, modTime: t})
+			node.info.modTime = t
+		}
+		return node, nil
+	case parts[0] == "versions":
+		found := false
+		for i, n := range names {
+			if n == parts[1] {
+				version, found = versions[i], true
+			}
+		}
+		if !found {
+			v, err := LookupVersion(parts[1])
+			if err != nil {
+				return nil, os.ErrNotExist
+			}
+			version = v
+		}
+		if len(parts) == 3 {
+			rest = parts[2]
+		}
+	case parts[0] == "latest" && len(versions) > 0:
+		version = versions[len(versions)-1]
+		rest = strings.TrimPrefix(strings.TrimPrefix(p, "latest"), "/")
+	default:
+		return nil, os.ErrNotExist
+	}
+
+	files, err := fs.versionFiles(version.Sha1)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := ""
+	node := &davNode{info: &davInfo{name: path.Base("/" + p), dir: true, modTime: parseTimestamp(version.Timestamp)}}
+	if rest != "" {
+		if f := SearchFile(files, rest); f != nil {
+			return &davNode{info: newDavInfo(*f), file: f}, nil
+		}
+		f := SearchFile(files, rest+"/")
+		if f == nil {
+			return nil, os.ErrNotExist
+		}
+		dir = f.Path
+		node.info = newDavInfo(*f)
+	}
+	for _, f := range ChildFiles(files, dir) {
+		node.children = append(node.children, newDavInfo(f))
+	}
+	return node, nil
+}
+
+func (fs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	node, err := fs.find(name)
+	if err != nil {
+		return nil, err
+	}
+	return node.info, nil
+}
+
+func (fs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	node, err := fs.find(name)
+	if err != nil {
+		return nil, err
+	}
+	if node.file == nil {
+		return &davDir{node: node}, nil
+	}
+	f, err := os.Open(GetObjectPath(node.file.Sha1))
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{File: f, node: node}, nil
+}
+
+func (fs *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (fs *davFS) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (fs *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+type davFile struct {
+	*os.File
+	node *davNode
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	return f.node.info, nil
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+type davDir struct {
+	node   *davNode
+	offset int
+}
+
+func (d *davDir) Close() error                                 { return nil }
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (d *davDir) Stat() (os.FileInfo, error)                   { return d.node.info, nil }
+
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	children := d.node.children[d.offset:]
+	if count <= 0 {
+		d.offset += len(children)
+		return children, nil
+	}
+	if len(children) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(children) {
+		count = len(children)
+	}
+	d.offset += count
+	return children[:count], nil
+}